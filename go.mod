@@ -5,4 +5,10 @@ go 1.16
 require (
 	github.com/confluentinc/confluent-kafka-go v1.9.2
 	github.com/grafana/grafana-plugin-sdk-go v0.102.0
+	github.com/jhump/protoreflect v1.12.0
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/common v0.23.0
+	github.com/vmihailenco/msgpack/v4 v4.3.13
+	golang.org/x/text v0.3.5
+	google.golang.org/protobuf v1.28.0
 )
@@ -1,113 +1,893 @@
 package kafka_client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"golang.org/x/text/encoding"
 )
 
 const MAX_EARLIEST int64 = 100
 
+const DEFAULT_GROUP_ID = "kafka-datasource"
+
+// Maximum length of the group instance ids, like the topic names.
+const MAX_GROUP_INSTANCE_ID_LENGTH = 249
+
+// Partition of the queries consuming all the partitions, kafka.PartitionAny.
+const ALL_PARTITIONS int32 = -1
+
+// Maximum duration of the bounded reads of a partition.
+const READ_TIMEOUT = 10 * time.Second
+
+// Topic the deep health check produces to and consumes from.
+const HEALTHCHECK_TOPIC = "_grafana_healthcheck"
+
+const DEFAULT_SECURITY_PROTOCOL = "PLAINTEXT"
+
+const SASL_MECHANISM_GSSAPI = "GSSAPI"
+const SASL_MECHANISM_PLAIN = "PLAIN"
+
+// Security protocol of the API keys of Confluent Cloud.
+const API_KEY_SECURITY_PROTOCOL = "SASL_SSL"
+
+var SECURITY_PROTOCOLS = []string{"PLAINTEXT", "SSL", "SASL_PLAINTEXT", "SASL_SSL"}
+
+// Trust store of the SSL connections: the CA bundle of the system, located
+// by librdkafka, or the CA certificate pasted in the settings.
+const TLS_CA_MODE_SYSTEM = "system"
+const TLS_CA_MODE_PROVIDED = "provided"
+
+var TLS_CA_MODES = []string{TLS_CA_MODE_SYSTEM, TLS_CA_MODE_PROVIDED}
+
+// AUTO_OFFSET_RESET_ERROR consumes from the committed offsets, failing when
+// there are none instead of resetting them.
+const AUTO_OFFSET_RESET_ERROR = "error"
+
+var AUTO_OFFSET_RESETS = []string{"earliest", "latest", AUTO_OFFSET_RESET_ERROR}
+
+var PARTITION_ASSIGNMENT_STRATEGIES = []string{"range", "roundrobin", "cooperative-sticky"}
+
+var ISOLATION_LEVELS = []string{"read_uncommitted", "read_committed"}
+
+var BROKER_ADDRESS_FAMILIES = []string{"any", "v4", "v6"}
+
+var CLIENT_DNS_LOOKUPS = []string{"use_all_dns_ips", "resolve_canonical_bootstrap_servers_only"}
+
+var ErrNoConsumer = errors.New("the consumer is not initialized or already closed")
+
+var ErrUnknownPartition = errors.New("unknown partition")
+
+// ErrReadTimeout tells that a bounded read gave up after READ_TIMEOUT, with
+// the messages read so far.
+var ErrReadTimeout = errors.New("timed out reading the partition")
+
+// Timeout of the metadata and offsets requests when neither the metadata nor
+// the health check timeout is set.
+const DEFAULT_METADATA_TIMEOUT_MS int32 = 2000
+
+// librdkafka defaults, used to validate partially configured timeouts.
+const DEFAULT_SESSION_TIMEOUT_MS int32 = 45000
+const DEFAULT_HEARTBEAT_INTERVAL_MS int32 = 3000
+const DEFAULT_MAX_POLL_INTERVAL_MS int32 = 300000
+const MAX_MAX_POLL_INTERVAL_MS int32 = 86400000
+
+// librdkafka defaults and limit of the fetch sizes.
+const DEFAULT_FETCH_MAX_BYTES int32 = 52428800
+const DEFAULT_RECEIVE_MESSAGE_MAX_BYTES int32 = 100000000
+const MAX_FETCH_MESSAGE_MAX_BYTES int32 = 1000000000
+
+// librdkafka defaults and limit of the time the brokers hold a fetch
+// waiting for messages, which must leave a second to the socket timeout.
+const DEFAULT_FETCH_WAIT_MAX_MS int32 = 500
+const DEFAULT_SOCKET_TIMEOUT_MS int32 = 60000
+const MAX_FETCH_WAIT_MAX_MS int32 = 300000
+
+// librdkafka limit of the reconnect backoffs.
+const MAX_RECONNECT_BACKOFF_MS int32 = 3600000
+
+// librdkafka limits of the prefetch queue.
+const MAX_QUEUED_MAX_MESSAGES_KBYTES int32 = 2097151
+const MAX_QUEUED_MIN_MESSAGES int32 = 10000000
+
 type Options struct {
 	BootstrapServers string `json:"bootstrapServers"`
 	SecurityProtocol string `json:"securityProtocol"`
 	SaslMechanisms   string `json:"saslMechanisms"`
 	SaslUsername     string `json:"saslUsername"`
 	SaslPassword     string `json:"saslPassword"`
+	// Confluent Cloud API key and secret, used as the SASL PLAIN username
+	// and password over SASL_SSL.
+	APIKey    string `json:"apiKey"`
+	APISecret string `json:"apiSecret"`
 	// TODO: If Debug is before HealthcheckTimeout, then json.Unmarshall
 	// silently fails to parse the timeout from the s.JSONData.  Figure out why.
-	HealthcheckTimeout int32  `json:"healthcheckTimeout"`
-	Debug              string `json:"debug"`
+	HealthcheckTimeout  int32  `json:"healthcheckTimeout"`
+	Debug               string `json:"debug"`
+	SessionTimeoutMs    int32  `json:"sessionTimeoutMs"`
+	HeartbeatIntervalMs int32  `json:"heartbeatIntervalMs"`
+	MaxMessageBytes     int32  `json:"maxMessageBytes"`
+	MaxPollIntervalMs   int32  `json:"maxPollIntervalMs"`
+	// Time the brokers hold a fetch waiting for messages, to be lowered for
+	// the latency of the live panels of low-rate topics.
+	FetchWaitMaxMs int32 `json:"fetchWaitMaxMs"`
+	// Timeouts of the metadata requests and of the requests to the brokers,
+	// to be raised for distant clusters.
+	MetadataTimeoutMs int32 `json:"metadataTimeoutMs"`
+	SocketTimeoutMs   int32 `json:"socketTimeoutMs"`
+	// Initial and maximum delays between the reconnections to a broker.
+	ReconnectBackoffMs    int32 `json:"reconnectBackoffMs"`
+	ReconnectBackoffMaxMs int32 `json:"reconnectBackoffMaxMs"`
+	// Bounds of the messages prefetched by every consumer, which bound its
+	// memory along with the fetch sizes.
+	QueuedMaxMessagesKbytes int32 `json:"queuedMaxMessagesKbytes"`
+	QueuedMinMessages       int32 `json:"queuedMinMessages"`
+	// Kerberos settings, used with the GSSAPI SASL mechanism.
+	SaslKerberosServiceName string `json:"saslKerberosServiceName"`
+	SaslKerberosPrincipal   string `json:"saslKerberosPrincipal"`
+	SaslKerberosKeytab      string `json:"saslKerberosKeytab"`
+	SaslKerberosKinitCmd    string `json:"saslKerberosKinitCmd"`
+	// Used by the queries which don't set a topic.
+	DefaultTopic     string `json:"defaultTopic"`
+	DefaultPartition int32  `json:"defaultPartition"`
+	// Used by the queries which don't set an auto offset reset.
+	AutoOffsetReset string `json:"autoOffsetReset"`
+	// Streams stop after that many consecutive reconnections, 0 retries forever.
+	MaxReconnectAttempts int32 `json:"maxReconnectAttempts"`
+	// Idle streams send an empty frame at that interval, 0 sends none.
+	KeepaliveIntervalMs int32 `json:"keepaliveIntervalMs"`
+	// Streams beyond that many fail at once, 0 allows any number.
+	MaxConcurrentStreams int32 `json:"maxConcurrentStreams"`
+	// The streams can be paused and resumed by publishing to their channel.
+	AllowStreamControl bool `json:"allowStreamControl"`
+	// Resolution of the broker addresses, for IPv6-only or proxied clusters.
+	BrokerAddressFamily string `json:"brokerAddressFamily"`
+	ClientDnsLookup     string `json:"clientDnsLookup"`
+	// Charset of the message values, transcoded to UTF-8 before decoding.
+	Charset        string `json:"charset"`
+	InvalidCharset string `json:"invalidCharset"`
+	// Time to live of the cached broker metadata, DEFAULT_METADATA_CACHE_TTL_MS
+	// when not set.
+	MetadataCacheTtlMs int32 `json:"metadataCacheTtlMs"`
+	// Interval of the librdkafka statistics of the stream consumers, 0
+	// disables them.
+	StatisticsIntervalMs int32 `json:"statisticsIntervalMs"`
+	// Schema registry of the protobuf-sr format.
+	SchemaRegistryUrl      string `json:"schemaRegistryUrl"`
+	SchemaRegistryUsername string `json:"schemaRegistryUsername"`
+	SchemaRegistryPassword string `json:"schemaRegistryPassword"`
+	// librdkafka's automatic offset commit, disabled by default.
+	EnableAutoCommit     bool  `json:"enableAutoCommit"`
+	AutoCommitIntervalMs int32 `json:"autoCommitIntervalMs"`
+	// The health check also produces a message and consumes it back, which
+	// requires the produce ACLs on HEALTHCHECK_TOPIC.
+	DeepHealthCheck bool `json:"deepHealthCheck"`
+	// read_committed hides the records of the aborted transactions.
+	IsolationLevel string `json:"isolationLevel"`
+	// The messages of the internal topics are only read when allowed.
+	AllowInternalTopics bool `json:"allowInternalTopics"`
+	// CA of the SSL and SASL_SSL protocols, TLS_CA_MODE_SYSTEM when not set.
+	TlsCaMode string `json:"tlsCaMode"`
+	TlsCaCert string `json:"tlsCaCert"`
+	// Assignment of the partitions subscribed to by the consumer groups.
+	PartitionAssignmentStrategy string `json:"partitionAssignmentStrategy"`
+	// Static membership of the consumer groups, suffixed with the host name
+	// so that every Grafana replica has its own.
+	GroupInstanceId string `json:"groupInstanceId"`
+	// Rack of the consumers, which fetch from the replicas of the same rack
+	// when the brokers select replicas by rack.
+	ClientRack string `json:"clientRack"`
+	// Servers the queries may read from instead of the bootstrap servers,
+	// with the same credentials. The queries can't override them when empty.
+	AllowedBootstrapServers string `json:"allowedBootstrapServers"`
+}
+
+// ApplyDefaults fills in the options left empty in the datasource settings.
+func (options *Options) ApplyDefaults() {
+	if options.MetadataCacheTtlMs == 0 {
+		options.MetadataCacheTtlMs = DEFAULT_METADATA_CACHE_TTL_MS
+	}
+	options.BootstrapServers = normalizeBootstrapServers(options.BootstrapServers)
+	options.AllowedBootstrapServers = normalizeBootstrapServers(options.AllowedBootstrapServers)
+	options.SecurityProtocol = strings.ToUpper(strings.TrimSpace(options.SecurityProtocol))
+	// The API key fills in the SASL settings left empty, the ones entered
+	// along with it fail the validation instead of being replaced.
+	if options.APIKey != "" {
+		if options.SaslMechanisms == "" {
+			options.SaslMechanisms = SASL_MECHANISM_PLAIN
+		}
+		if options.SaslUsername == "" && options.SaslPassword == "" {
+			options.SaslUsername = options.APIKey
+			options.SaslPassword = options.APISecret
+		}
+		if options.SecurityProtocol == "" {
+			options.SecurityProtocol = API_KEY_SECURITY_PROTOCOL
+		}
+	}
+	if options.SecurityProtocol == "" {
+		options.SecurityProtocol = DEFAULT_SECURITY_PROTOCOL
+	}
+	if options.TlsCaMode == "" && options.usesTls() {
+		options.TlsCaMode = TLS_CA_MODE_SYSTEM
+	}
+}
+
+// normalizeBootstrapServers cleans up a pasted list of servers, dropping the
+// empty entries, like the one after a trailing comma, and the schemes of the
+// URLs, like kafka://.
+func normalizeBootstrapServers(servers string) string {
+	var normalized []string
+	for _, server := range strings.Split(servers, ",") {
+		server = strings.TrimSpace(server)
+		if i := strings.Index(server, "://"); i >= 0 {
+			server = server[i+3:]
+		}
+		server = strings.TrimRight(server, "/")
+		if server != "" {
+			normalized = append(normalized, server)
+		}
+	}
+
+	return strings.Join(normalized, ",")
+}
+
+// groupInstanceId suffixes the configured group instance id with the host
+// name, which tells the Grafana replicas apart.
+func groupInstanceId(id string) string {
+	if id == "" {
+		return ""
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return id
+	}
+	return id + "-" + hostname
+}
+
+// validateGroupInstanceId checks the characters of a group instance id, the
+// ones of the topic names.
+func validateGroupInstanceId(id string) error {
+	if len(id) > MAX_GROUP_INSTANCE_ID_LENGTH {
+		return fmt.Errorf("group instance id must not be longer than %d characters", MAX_GROUP_INSTANCE_ID_LENGTH)
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return fmt.Errorf("invalid group instance id %q, expected letters, digits, '.', '_' and '-'", id)
+		}
+	}
+	return nil
+}
+
+// QueryBootstrapServers checks the servers a query reads from instead of the
+// bootstrap servers, which must all be allowed, and returns them normalized.
+func (options Options) QueryBootstrapServers(servers string) (string, error) {
+	if options.AllowedBootstrapServers == "" {
+		return "", errors.New("the data source doesn't allow the queries to override the bootstrap servers")
+	}
+	servers = normalizeBootstrapServers(servers)
+	allowed := strings.Split(options.AllowedBootstrapServers, ",")
+	for _, server := range strings.Split(servers, ",") {
+		if !contains(allowed, server) {
+			return "", fmt.Errorf("bootstrap server %q is not allowed by the data source", server)
+		}
+	}
+
+	return servers, nil
+}
+
+// Placeholder of the secrets left out of the diagnostics.
+const REDACTED = "********"
+
+// Redacted returns the options with their secrets masked, to show them
+// without leaking the credentials. The username is masked along with the
+// API key it was set to.
+func (options Options) Redacted() Options {
+	for _, secret := range []*string{&options.SaslPassword, &options.APIKey, &options.APISecret,
+		&options.TlsCaCert, &options.SchemaRegistryPassword} {
+		if *secret != "" {
+			*secret = REDACTED
+		}
+	}
+	if options.APIKey != "" && options.SaslUsername != "" {
+		options.SaslUsername = REDACTED
+	}
+
+	return options
+}
+
+// validateBootstrapServer checks a host:port server, the port defaulting to
+// 9092 when left out.
+func validateBootstrapServer(server string) error {
+	if !strings.Contains(server, ":") || (strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]")) {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap server %q, expected host:port: %w", server, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid bootstrap server %q, the host is missing", server)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("invalid bootstrap server %q, the port must be between 1 and 65535", server)
+	}
+
+	return nil
+}
+
+func (options Options) usesTls() bool {
+	return strings.HasSuffix(options.SecurityProtocol, "SSL")
+}
+
+func (options Options) Validate() error {
+	for _, servers := range []string{options.BootstrapServers, options.AllowedBootstrapServers} {
+		if servers == "" {
+			continue
+		}
+		for _, server := range strings.Split(servers, ",") {
+			if err := validateBootstrapServer(server); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !contains(SECURITY_PROTOCOLS, options.SecurityProtocol) {
+		return fmt.Errorf("invalid security protocol %q, expected one of %s",
+			options.SecurityProtocol, strings.Join(SECURITY_PROTOCOLS, ", "))
+	}
+
+	if options.APIKey != "" {
+		if options.APISecret == "" {
+			return errors.New("the API key requires its API secret")
+		}
+		if options.SecurityProtocol != API_KEY_SECURITY_PROTOCOL {
+			return fmt.Errorf("the API key requires the %s security protocol, got %s",
+				API_KEY_SECURITY_PROTOCOL, options.SecurityProtocol)
+		}
+		if options.SaslUsername != options.APIKey || options.SaslPassword != options.APISecret {
+			return errors.New("the API key is used instead of the SASL username and password, clear them")
+		}
+		if options.SaslMechanisms != SASL_MECHANISM_PLAIN {
+			return fmt.Errorf("the API key requires the %s SASL mechanism, got %s",
+				SASL_MECHANISM_PLAIN, options.SaslMechanisms)
+		}
+	}
+
+	if err := validateGroupInstanceId(options.GroupInstanceId); err != nil {
+		return err
+	}
+
+	if options.TlsCaMode != "" && !contains(TLS_CA_MODES, options.TlsCaMode) {
+		return fmt.Errorf("invalid TLS CA mode %q, expected one of %s",
+			options.TlsCaMode, strings.Join(TLS_CA_MODES, ", "))
+	}
+	if options.TlsCaMode == TLS_CA_MODE_PROVIDED && options.usesTls() {
+		if block, _ := pem.Decode([]byte(options.TlsCaCert)); block == nil {
+			return errors.New("the provided TLS CA mode requires a PEM encoded CA certificate")
+		}
+	}
+
+	if options.AutoOffsetReset != "" && !contains(AUTO_OFFSET_RESETS, options.AutoOffsetReset) {
+		return fmt.Errorf("invalid auto offset reset %q, expected one of %s",
+			options.AutoOffsetReset, strings.Join(AUTO_OFFSET_RESETS, ", "))
+	}
+
+	if options.PartitionAssignmentStrategy != "" &&
+		!contains(PARTITION_ASSIGNMENT_STRATEGIES, options.PartitionAssignmentStrategy) {
+		return fmt.Errorf("invalid partition assignment strategy %q, expected one of %s",
+			options.PartitionAssignmentStrategy, strings.Join(PARTITION_ASSIGNMENT_STRATEGIES, ", "))
+	}
+
+	if options.IsolationLevel != "" && !contains(ISOLATION_LEVELS, options.IsolationLevel) {
+		return fmt.Errorf("invalid isolation level %q, expected one of %s",
+			options.IsolationLevel, strings.Join(ISOLATION_LEVELS, ", "))
+	}
+
+	if options.BrokerAddressFamily != "" && !contains(BROKER_ADDRESS_FAMILIES, options.BrokerAddressFamily) {
+		return fmt.Errorf("invalid broker address family %q, expected one of %s",
+			options.BrokerAddressFamily, strings.Join(BROKER_ADDRESS_FAMILIES, ", "))
+	}
+
+	if options.ClientDnsLookup != "" && !contains(CLIENT_DNS_LOOKUPS, options.ClientDnsLookup) {
+		return fmt.Errorf("invalid client DNS lookup %q, expected one of %s",
+			options.ClientDnsLookup, strings.Join(CLIENT_DNS_LOOKUPS, ", "))
+	}
+
+	if _, err := lookupCharset(options.Charset); err != nil {
+		return err
+	}
+
+	if options.InvalidCharset != "" && !contains(INVALID_CHARSET_POLICIES, options.InvalidCharset) {
+		return fmt.Errorf("invalid charset policy %q, expected one of %s",
+			options.InvalidCharset, strings.Join(INVALID_CHARSET_POLICIES, ", "))
+	}
+
+	if options.MaxReconnectAttempts < 0 {
+		return errors.New("max reconnect attempts must not be negative")
+	}
+
+	if options.KeepaliveIntervalMs < 0 {
+		return errors.New("keepalive interval must not be negative")
+	}
+
+	if options.MaxConcurrentStreams < 0 {
+		return errors.New("max concurrent streams must not be negative")
+	}
+
+	if options.AutoCommitIntervalMs < 0 {
+		return errors.New("auto commit interval must not be negative")
+	}
+
+	if options.MetadataCacheTtlMs < 0 {
+		return errors.New("metadata cache TTL must not be negative")
+	}
+
+	if options.StatisticsIntervalMs < 0 || options.StatisticsIntervalMs > MAX_STATISTICS_INTERVAL_MS {
+		return fmt.Errorf("statistics interval must be between 0 and %d ms", MAX_STATISTICS_INTERVAL_MS)
+	}
+
+	if options.MaxMessageBytes < 0 || options.MaxMessageBytes > MAX_FETCH_MESSAGE_MAX_BYTES {
+		return fmt.Errorf("max message bytes must be between 0 and %d", MAX_FETCH_MESSAGE_MAX_BYTES)
+	}
+
+	if options.FetchWaitMaxMs < 0 || options.FetchWaitMaxMs > MAX_FETCH_WAIT_MAX_MS {
+		return fmt.Errorf("fetch wait max must be between 0 and %d ms", MAX_FETCH_WAIT_MAX_MS)
+	}
+	fetchWaitMax, socketTimeout := options.FetchWaitMaxMs, options.SocketTimeoutMs
+	if fetchWaitMax == 0 {
+		fetchWaitMax = DEFAULT_FETCH_WAIT_MAX_MS
+	}
+	if socketTimeout == 0 {
+		socketTimeout = DEFAULT_SOCKET_TIMEOUT_MS
+	}
+	if socketTimeout < fetchWaitMax+1000 {
+		return fmt.Errorf("socket timeout (%dms) must be at least a second above the fetch wait max (%dms)",
+			socketTimeout, fetchWaitMax)
+	}
+
+	if options.DefaultPartition < kafka.PartitionAny {
+		return fmt.Errorf("invalid default partition %d", options.DefaultPartition)
+	}
+
+	if options.SaslMechanisms == SASL_MECHANISM_GSSAPI {
+		if !strings.HasPrefix(options.SecurityProtocol, "SASL_") {
+			return fmt.Errorf("the GSSAPI mechanism requires the SASL_PLAINTEXT or SASL_SSL security protocol, got %s",
+				options.SecurityProtocol)
+		}
+		if options.SaslKerberosKeytab != "" {
+			keytab, err := os.Open(options.SaslKerberosKeytab)
+			if err != nil {
+				return fmt.Errorf("kerberos keytab is not readable: %w", err)
+			}
+			keytab.Close()
+		}
+	}
+
+	if options.QueuedMaxMessagesKbytes < 0 || options.QueuedMaxMessagesKbytes > MAX_QUEUED_MAX_MESSAGES_KBYTES {
+		return fmt.Errorf("queued max messages kbytes must be between 0 and %d", MAX_QUEUED_MAX_MESSAGES_KBYTES)
+	}
+	if options.QueuedMinMessages < 0 || options.QueuedMinMessages > MAX_QUEUED_MIN_MESSAGES {
+		return fmt.Errorf("queued min messages must be between 0 and %d", MAX_QUEUED_MIN_MESSAGES)
+	}
+
+	if options.ReconnectBackoffMs < 0 || options.ReconnectBackoffMs > MAX_RECONNECT_BACKOFF_MS ||
+		options.ReconnectBackoffMaxMs < 0 || options.ReconnectBackoffMaxMs > MAX_RECONNECT_BACKOFF_MS {
+		return fmt.Errorf("reconnect backoffs must be between 0 and %d", MAX_RECONNECT_BACKOFF_MS)
+	}
+	if options.ReconnectBackoffMs > 0 && options.ReconnectBackoffMaxMs > 0 &&
+		options.ReconnectBackoffMaxMs < options.ReconnectBackoffMs {
+		return fmt.Errorf("maximum reconnect backoff (%dms) must not be lower than the reconnect backoff (%dms)",
+			options.ReconnectBackoffMaxMs, options.ReconnectBackoffMs)
+	}
+
+	if options.MetadataTimeoutMs < 0 || options.SocketTimeoutMs < 0 {
+		return errors.New("metadata and socket timeouts must not be negative")
+	}
+
+	if options.SessionTimeoutMs < 0 || options.HeartbeatIntervalMs < 0 {
+		return errors.New("session timeout and heartbeat interval must not be negative")
+	}
+
+	sessionTimeout := options.SessionTimeoutMs
+	if sessionTimeout == 0 {
+		sessionTimeout = DEFAULT_SESSION_TIMEOUT_MS
+	}
+	heartbeatInterval := options.HeartbeatIntervalMs
+	if heartbeatInterval == 0 {
+		heartbeatInterval = DEFAULT_HEARTBEAT_INTERVAL_MS
+	}
+	if heartbeatInterval >= sessionTimeout/3 {
+		return fmt.Errorf("heartbeat interval (%dms) must be lower than a third of the session timeout (%dms)",
+			heartbeatInterval, sessionTimeout)
+	}
+
+	if options.MaxPollIntervalMs < 0 || options.MaxPollIntervalMs > MAX_MAX_POLL_INTERVAL_MS {
+		return fmt.Errorf("max poll interval must be between 0 and %d ms", MAX_MAX_POLL_INTERVAL_MS)
+	}
+	maxPollInterval := options.MaxPollIntervalMs
+	if maxPollInterval == 0 {
+		maxPollInterval = DEFAULT_MAX_POLL_INTERVAL_MS
+	}
+	if maxPollInterval < sessionTimeout {
+		return fmt.Errorf("max poll interval (%dms) must not be lower than the session timeout (%dms)",
+			maxPollInterval, sessionTimeout)
+	}
+
+	return nil
 }
 
 type KafkaClient struct {
-	Consumer           *kafka.Consumer
-	BootstrapServers   string
-	TimestampMode      string
-	SecurityProtocol   string
-	SaslMechanisms     string
-	SaslUsername       string
-	SaslPassword       string
-	Debug              string
-	HealthcheckTimeout int32
+	Consumer         *kafka.Consumer
+	GroupId          string
+	BootstrapServers string
+	TimestampMode    string
+	Decode           DecodeOptions
+	AutoOffsetReset  string
+	PrefetchLast     int64
+	// Time the partitions are consumed from when set, which takes precedence
+	// over the auto offset reset and the prefetch.
+	StartTime time.Time
+	// Time past which the partitions end when set, see Ended.
+	EndTime time.Time
+	// Offsets the partitions are assigned at and end at with an end time.
+	endOffsets map[partitionKey]offsetRange
+	// The partitions are consumed from their earliest offset, without the
+	// MAX_EARLIEST bound, and the ends of the partitions reached are tracked
+	// to tell when their history was read.
+	FromBeginning bool
+	endReached    map[partitionKey]bool
+	// Offsets following the last message consumed from every partition,
+	// which the reassignments resume from instead of the start of the
+	// stream, so that a reconnection doesn't replay the messages sent.
+	resumeOffsets map[partitionKey]int64
+	// All the partitions of the topic are read by workers, with a consumer
+	// each, rather than by a consumer of the group.
+	ParallelPartitions bool
+	workers            *partitionWorkers
+	// Offset the assigned partition is consumed from, kafka.OffsetEnd when
+	// tailing it and kafka.OffsetInvalid when partitions are subscribed to.
+	StartOffset                 int64
+	SecurityProtocol            string
+	SaslMechanisms              string
+	SaslUsername                string
+	SaslPassword                string
+	Debug                       string
+	HealthcheckTimeout          int32
+	SessionTimeoutMs            int32
+	HeartbeatIntervalMs         int32
+	MaxPollIntervalMs           int32
+	FetchWaitMaxMs              int32
+	MetadataTimeoutMs           int32
+	SocketTimeoutMs             int32
+	ReconnectBackoffMs          int32
+	ReconnectBackoffMaxMs       int32
+	QueuedMaxMessagesKbytes     int32
+	QueuedMinMessages           int32
+	MaxMessageBytes             int32
+	SaslKerberosServiceName     string
+	SaslKerberosPrincipal       string
+	SaslKerberosKeytab          string
+	SaslKerberosKinitCmd        string
+	BrokerAddressFamily         string
+	ClientDnsLookup             string
+	InvalidCharset              string
+	EnableAutoCommit            bool
+	AutoCommitIntervalMs        int32
+	IsolationLevel              string
+	PartitionAssignmentStrategy string
+	TlsCaMode                   string
+	TlsCaCert                   string
+	ClientRack                  string
+	GroupInstanceId             string
+	StatisticsIntervalMs        int32
+	// Identifies the stream of the client across the restarts of Grafana,
+	// which the static memberships of its subscriptions are named after.
+	StreamId string
+	charset  encoding.Encoding
+	// Shared by the clients of a datasource, may be nil.
+	MetadataCache  *MetadataCache
+	SchemaRegistry *SchemaRegistry
+	Pool           *ConsumerPool
+	Stats          *StatsRecorder
+	// Set for the consumers of the streams, the only ones polled long
+	// enough to emit statistics, along with the name of the consumer in
+	// the recorded statistics.
+	streaming     bool
+	statsConsumer string
+	// Static membership of the consumer of a subscription, see subscribe.
+	groupInstanceId string
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
 }
 
-type KafkaMessage struct {
-	Value     map[string]float64
-	Timestamp time.Time
-	Offset    kafka.Offset
+type offsetRange struct {
+	start int64
+	end   int64
+}
+
+// ConsumedMessage is a decoded Kafka message along with its metadata.
+type ConsumedMessage struct {
+	// A message holds several records with the jsonarray and ndjson formats.
+	Values      []map[string]interface{}
+	Key         []byte
+	Value       []byte
+	Headers     map[string]string
+	Topic       string
+	Partition   int32
+	Offset      int64
+	Timestamp   time.Time
+	DecodeError error
+	// Tombstones, null values of compacted topics, mark the deletion of
+	// their key.
+	Tombstone bool
+}
+
+// Fields of the row of a tombstone.
+const TOMBSTONE_FIELD = "__tombstone"
+const TOMBSTONE_KEY_FIELD = "__key"
+
+type PreviewMessage struct {
+	Offset      int64       `json:"offset"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Key         []byte      `json:"key"`
+	Raw         []byte      `json:"raw"`
+	Value       interface{} `json:"value,omitempty"`
+	DecodeError string      `json:"decodeError,omitempty"`
+	Tombstone   bool        `json:"tombstone,omitempty"`
 }
 
 func NewKafkaClient(options Options) KafkaClient {
 	client := KafkaClient{
-		BootstrapServers:   options.BootstrapServers,
-		SecurityProtocol:   options.SecurityProtocol,
-		SaslMechanisms:     options.SaslMechanisms,
-		SaslUsername:       options.SaslUsername,
-		SaslPassword:       options.SaslPassword,
-		Debug:              options.Debug,
-		HealthcheckTimeout: options.HealthcheckTimeout,
+		GroupId:                     DEFAULT_GROUP_ID,
+		BootstrapServers:            options.BootstrapServers,
+		SecurityProtocol:            options.SecurityProtocol,
+		SaslMechanisms:              options.SaslMechanisms,
+		SaslUsername:                options.SaslUsername,
+		SaslPassword:                options.SaslPassword,
+		Debug:                       options.Debug,
+		HealthcheckTimeout:          options.HealthcheckTimeout,
+		SessionTimeoutMs:            options.SessionTimeoutMs,
+		MaxPollIntervalMs:           options.MaxPollIntervalMs,
+		FetchWaitMaxMs:              options.FetchWaitMaxMs,
+		HeartbeatIntervalMs:         options.HeartbeatIntervalMs,
+		MetadataTimeoutMs:           options.MetadataTimeoutMs,
+		SocketTimeoutMs:             options.SocketTimeoutMs,
+		ReconnectBackoffMs:          options.ReconnectBackoffMs,
+		ReconnectBackoffMaxMs:       options.ReconnectBackoffMaxMs,
+		QueuedMaxMessagesKbytes:     options.QueuedMaxMessagesKbytes,
+		QueuedMinMessages:           options.QueuedMinMessages,
+		MaxMessageBytes:             options.MaxMessageBytes,
+		SaslKerberosServiceName:     options.SaslKerberosServiceName,
+		SaslKerberosPrincipal:       options.SaslKerberosPrincipal,
+		SaslKerberosKeytab:          options.SaslKerberosKeytab,
+		SaslKerberosKinitCmd:        options.SaslKerberosKinitCmd,
+		BrokerAddressFamily:         options.BrokerAddressFamily,
+		ClientDnsLookup:             options.ClientDnsLookup,
+		InvalidCharset:              options.InvalidCharset,
+		EnableAutoCommit:            options.EnableAutoCommit,
+		AutoCommitIntervalMs:        options.AutoCommitIntervalMs,
+		IsolationLevel:              options.IsolationLevel,
+		PartitionAssignmentStrategy: options.PartitionAssignmentStrategy,
+		TlsCaMode:                   options.TlsCaMode,
+		TlsCaCert:                   options.TlsCaCert,
+		ClientRack:                  strings.TrimSpace(options.ClientRack),
+		GroupInstanceId:             groupInstanceId(options.GroupInstanceId),
+		StatisticsIntervalMs:        options.StatisticsIntervalMs,
 	}
+	// The charset was checked by Options.Validate.
+	client.charset, _ = lookupCharset(options.Charset)
 	return client
 }
 
-func (client *KafkaClient) consumerInitialize() {
-	var err error
-
+// connectionConfig holds the settings shared by the consumers and producers
+// to connect and authenticate to the brokers.
+func (client *KafkaClient) connectionConfig() kafka.ConfigMap {
 	config := kafka.ConfigMap{
-		"bootstrap.servers":  client.BootstrapServers,
-		"group.id":           "kafka-datasource",
-		"enable.auto.commit": "false",
+		"bootstrap.servers": client.BootstrapServers,
+		"security.protocol": client.SecurityProtocol,
 	}
 
-	if client.SecurityProtocol != "" {
-		config.SetKey("security.protocol", client.SecurityProtocol)
+	if strings.HasSuffix(client.SecurityProtocol, "SSL") {
+		switch client.TlsCaMode {
+		case TLS_CA_MODE_SYSTEM:
+			// The statically linked librdkafka doesn't know where the
+			// distribution keeps its CA bundle.
+			config.SetKey("ssl.ca.location", "probe")
+		case TLS_CA_MODE_PROVIDED:
+			config.SetKey("ssl.ca.pem", client.TlsCaCert)
+		}
 	}
 	if client.SaslMechanisms != "" {
 		config.SetKey("sasl.mechanisms", client.SaslMechanisms)
 	}
-	if client.SaslMechanisms != "" {
+	if client.SaslMechanisms == SASL_MECHANISM_GSSAPI {
+		if client.SaslKerberosServiceName != "" {
+			config.SetKey("sasl.kerberos.service.name", client.SaslKerberosServiceName)
+		}
+		if client.SaslKerberosPrincipal != "" {
+			config.SetKey("sasl.kerberos.principal", client.SaslKerberosPrincipal)
+		}
+		if client.SaslKerberosKeytab != "" {
+			config.SetKey("sasl.kerberos.keytab", client.SaslKerberosKeytab)
+		}
+		if client.SaslKerberosKinitCmd != "" {
+			config.SetKey("sasl.kerberos.kinit.cmd", client.SaslKerberosKinitCmd)
+		}
+	} else if client.SaslMechanisms != "" {
 		config.SetKey("sasl.username", client.SaslUsername)
-	}
-	if client.SaslMechanisms != "" {
 		config.SetKey("sasl.password", client.SaslPassword)
 	}
 	if client.Debug != "" {
 		config.SetKey("debug", client.Debug)
 	}
+	if client.MetadataTimeoutMs > 0 {
+		config.SetKey("metadata.request.timeout.ms", int(client.MetadataTimeoutMs))
+	}
+	if client.SocketTimeoutMs > 0 {
+		config.SetKey("socket.timeout.ms", int(client.SocketTimeoutMs))
+	}
+	if client.ReconnectBackoffMs > 0 {
+		config.SetKey("reconnect.backoff.ms", int(client.ReconnectBackoffMs))
+	}
+	if client.ReconnectBackoffMaxMs > 0 {
+		config.SetKey("reconnect.backoff.max.ms", int(client.ReconnectBackoffMaxMs))
+	}
+	if client.BrokerAddressFamily != "" {
+		config.SetKey("broker.address.family", client.BrokerAddressFamily)
+	}
+	if client.ClientDnsLookup != "" {
+		config.SetKey("client.dns.lookup", client.ClientDnsLookup)
+	}
 
-	client.Consumer, err = kafka.NewConsumer(&config)
+	return config
+}
 
-	if err != nil {
-		panic(err)
+// ConsumerConfig returns the librdkafka settings of the consumers of the
+// client, defaults included. They hold the secrets of the client, which a
+// client of redacted options masks.
+func (client *KafkaClient) ConsumerConfig() map[string]interface{} {
+	config := client.consumerConfig()
+	settings := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		settings[key] = value
 	}
+
+	return settings
 }
 
-func (client *KafkaClient) TopicAssign(topic string, partition int32, autoOffsetReset string,
-	timestampMode string) {
-	client.consumerInitialize()
-	client.TimestampMode = timestampMode
+func (client *KafkaClient) consumerInitialize() error {
 	var err error
-	var offset int64
-	var high, low int64
-	switch autoOffsetReset {
-	case "latest":
-		offset = int64(kafka.OffsetEnd)
-	case "earliest":
-		low, high, err = client.Consumer.QueryWatermarkOffsets(topic, partition, 100)
-		if err != nil {
-			panic(err)
+
+	config := client.consumerConfig()
+	client.Consumer, err = kafka.NewConsumer(&config)
+
+	return err
+}
+
+// boundedConsumerInitialize sets the consumer of the bounded reads, which
+// detect the end of the partitions: compacted partitions may not hold a
+// message at the offset before the high watermark.
+func (client *KafkaClient) boundedConsumerInitialize() error {
+	var err error
+
+	config := client.consumerConfig()
+	config.SetKey("enable.partition.eof", true)
+	client.Consumer, err = kafka.NewConsumer(&config)
+
+	return err
+}
+
+func (client *KafkaClient) consumerConfig() kafka.ConfigMap {
+	config := client.connectionConfig()
+	config.SetKey("group.id", client.GroupId)
+	config.SetKey("enable.auto.commit", client.EnableAutoCommit)
+	if client.EnableAutoCommit && client.AutoCommitIntervalMs > 0 {
+		config.SetKey("auto.commit.interval.ms", int(client.AutoCommitIntervalMs))
+	}
+	if client.IsolationLevel != "" {
+		config.SetKey("isolation.level", client.IsolationLevel)
+	}
+	if client.PartitionAssignmentStrategy != "" {
+		config.SetKey("partition.assignment.strategy", client.PartitionAssignmentStrategy)
+	}
+	if client.AutoOffsetReset != "" {
+		config.SetKey("auto.offset.reset", client.AutoOffsetReset)
+	}
+	if client.streaming && client.StatisticsIntervalMs > 0 {
+		config.SetKey("statistics.interval.ms", int(client.StatisticsIntervalMs))
+	}
+	if client.ClientRack != "" {
+		config.SetKey("client.rack", client.ClientRack)
+	}
+	if client.FromBeginning {
+		config.SetKey("enable.partition.eof", true)
+	}
+	if client.groupInstanceId != "" {
+		config.SetKey("group.instance.id", client.groupInstanceId)
+	}
+	if client.SessionTimeoutMs > 0 {
+		config.SetKey("session.timeout.ms", int(client.SessionTimeoutMs))
+	}
+	if client.HeartbeatIntervalMs > 0 {
+		config.SetKey("heartbeat.interval.ms", int(client.HeartbeatIntervalMs))
+	}
+	if client.MaxPollIntervalMs > 0 {
+		config.SetKey("max.poll.interval.ms", int(client.MaxPollIntervalMs))
+	}
+	if client.QueuedMaxMessagesKbytes > 0 {
+		config.SetKey("queued.max.messages.kbytes", int(client.QueuedMaxMessagesKbytes))
+	}
+	if client.QueuedMinMessages > 0 {
+		config.SetKey("queued.min.messages", int(client.QueuedMinMessages))
+	}
+	if client.FetchWaitMaxMs > 0 {
+		config.SetKey("fetch.wait.max.ms", int(client.FetchWaitMaxMs))
+	}
+	if client.MaxMessageBytes > 0 {
+		// librdkafka requires the fetch size to fit in a received message,
+		// along with 512 bytes of protocol overhead.
+		fetchMaxBytes := DEFAULT_FETCH_MAX_BYTES
+		if client.MaxMessageBytes > fetchMaxBytes {
+			fetchMaxBytes = client.MaxMessageBytes
 		}
-		if high-low > MAX_EARLIEST {
-			offset = high - MAX_EARLIEST
-		} else {
-			offset = low
+		receiveMessageMaxBytes := DEFAULT_RECEIVE_MESSAGE_MAX_BYTES
+		if fetchMaxBytes+512 > receiveMessageMaxBytes {
+			receiveMessageMaxBytes = fetchMaxBytes + 512
 		}
-	default:
-		offset = int64(kafka.OffsetEnd)
+		config.SetKey("fetch.message.max.bytes", int(client.MaxMessageBytes))
+		config.SetKey("fetch.max.bytes", int(fetchMaxBytes))
+		config.SetKey("receive.message.max.bytes", int(receiveMessageMaxBytes))
+	}
+
+	return config
+}
+
+// TopicAssign assigns the consumer to the partition of the topic. When
+// tailing the latest messages, prefetchLast messages before the high
+// watermark are replayed first so the panel isn't blank on quiet topics.
+// The context cancels the wait for the partitions of a topic just created.
+func (client *KafkaClient) TopicAssign(ctx context.Context, topic string, partition int32, autoOffsetReset string,
+	timestampMode string, prefetchLast int64) error {
+	client.TimestampMode = timestampMode
+	client.PrefetchLast = prefetchLast
+	client.AutoOffsetReset = autoOffsetReset
+	client.StartOffset = int64(kafka.OffsetInvalid)
+
+	if partition == kafka.PartitionAny && client.ParallelPartitions {
+		return client.assignParallel(ctx, topic)
+	}
+	client.streaming = true
+	if partition == kafka.PartitionAny {
+		return client.subscribe(topic)
+	}
+
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
+	if err := client.checkPartition(ctx, topic, partition); err != nil {
+		return err
+	}
+	offset, err := client.startOffset(topic, partition, autoOffsetReset)
+	if err != nil {
+		return err
+	}
+	client.StartOffset = offset
+	if err := client.assignEnd(topic, partition, offset); err != nil {
+		return err
 	}
 
 	topic_partition := kafka.TopicPartition{
@@ -115,46 +895,439 @@ func (client *KafkaClient) TopicAssign(topic string, partition int32, autoOffset
 		Partition: partition,
 		Offset:    kafka.Offset(offset),
 		Metadata:  new(string),
-		Error:     err,
 	}
 	partitions := []kafka.TopicPartition{topic_partition}
-	err = client.Consumer.Assign(partitions)
 
+	return client.Consumer.Assign(partitions)
+}
+
+// checkPartition fails when the topic exists without the partition, which
+// would otherwise be assigned without ever yielding a message. It waits
+// briefly for the partitions of a topic the brokers don't know yet.
+func (client *KafkaClient) checkPartition(ctx context.Context, topic string, partition int32) error {
+	topicMetadata, exists, err := client.topicMetadata(ctx, topic)
+	if err != nil {
+		return err
+	}
+	if !exists || topicMetadata.Error.Code() != kafka.ErrNoError {
+		return nil
+	}
+	for _, p := range topicMetadata.Partitions {
+		if p.ID == partition {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: topic %s has %d partitions, %d is not one of them",
+		ErrUnknownPartition, topic, len(topicMetadata.Partitions), partition)
+}
+
+func (client *KafkaClient) DescribeStartOffset() string {
+	switch client.StartOffset {
+	case int64(kafka.OffsetEnd):
+		return "latest"
+	case int64(kafka.OffsetInvalid):
+		return "assigned by the consumer group"
+	case int64(kafka.OffsetStored):
+		return "committed"
+	default:
+		return fmt.Sprint(client.StartOffset)
+	}
+}
+
+// subscribe lets the consumer group assign all the partitions of the topic.
+// Every subscription gets its own group, so that concurrent panels reading
+// the same topic don't split its partitions between them. With a group
+// instance id, the group and the static membership are named after the
+// stream, so that a restarting replica rejoins the group of its stream
+// without a rebalance, while the other streams keep their own members.
+func (client *KafkaClient) subscribe(topic string) error {
+	client.GroupId = fmt.Sprintf("%s-%d", DEFAULT_GROUP_ID, time.Now().UnixNano())
+	client.groupInstanceId = ""
+	if client.GroupInstanceId != "" && client.StreamId != "" {
+		client.GroupId = fmt.Sprintf("%s-%s", DEFAULT_GROUP_ID, client.StreamId)
+		client.groupInstanceId = fmt.Sprintf("%s-%s", client.GroupInstanceId, client.StreamId)
+	}
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
+
+	return client.Consumer.Subscribe(topic, client.rebalance)
+}
+
+// rebalance applies the assignments of the consumer group, consuming the
+// assigned partitions from their start offset.
+func (client *KafkaClient) rebalance(consumer *kafka.Consumer, ev kafka.Event) error {
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		partitions := make([]kafka.TopicPartition, len(e.Partitions))
+		for i, partition := range e.Partitions {
+			offset, err := client.startOffset(*partition.Topic, partition.Partition, client.AutoOffsetReset)
+			if err != nil {
+				return err
+			}
+			if err := client.assignEnd(*partition.Topic, partition.Partition, offset); err != nil {
+				return err
+			}
+			partition.Offset = kafka.Offset(offset)
+			partitions[i] = partition
+		}
+		// The cooperative protocol only hands over the partitions
+		// which move, on top of the current assignment.
+		if consumer.GetRebalanceProtocol() == "COOPERATIVE" {
+			return consumer.IncrementalAssign(partitions)
+		}
+		return consumer.Assign(partitions)
+	case kafka.RevokedPartitions:
+		for _, partition := range e.Partitions {
+			delete(client.endOffsets, partitionKey{*partition.Topic, partition.Partition})
+		}
+		if consumer.GetRebalanceProtocol() == "COOPERATIVE" {
+			return consumer.IncrementalUnassign(e.Partitions)
+		}
+		return consumer.Unassign()
+	}
+	return nil
+}
+
+func (client *KafkaClient) startOffset(topic string, partition int32, autoOffsetReset string) (int64, error) {
+	if offset, exists := client.resumeOffsets[partitionKey{topic, partition}]; exists {
+		return offset, nil
+	}
+	if client.FromBeginning {
+		low, _, err := client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs())
+		return low, err
+	}
+	if !client.StartTime.IsZero() {
+		return client.offsetForTime(topic, partition, client.StartTime)
+	}
+
+	switch autoOffsetReset {
+	case AUTO_OFFSET_RESET_ERROR:
+		// librdkafka reports the partitions without committed offset.
+		return int64(kafka.OffsetStored), nil
+	case "earliest":
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs())
+		if err != nil {
+			return 0, err
+		}
+		if high-low > MAX_EARLIEST {
+			return high - MAX_EARLIEST, nil
+		}
+		return low, nil
+	default:
+		if client.PrefetchLast <= 0 {
+			return int64(kafka.OffsetEnd), nil
+		}
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs())
+		if err != nil {
+			return 0, err
+		}
+		if high-low > client.PrefetchLast {
+			return high - client.PrefetchLast, nil
+		}
+		return low, nil
+	}
+}
+
+// offsetForTime returns the offset of the first message of the partition at
+// or after the time, or kafka.OffsetEnd when there is none yet.
+func (client *KafkaClient) offsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	offsets, err := client.Consumer.OffsetsForTimes([]kafka.TopicPartition{{
+		Topic:     &topic,
+		Partition: partition,
+		Offset:    kafka.Offset(t.UnixNano() / int64(time.Millisecond)),
+	}}, client.metadataTimeoutMs())
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
+	if len(offsets) != 1 {
+		return 0, fmt.Errorf("no offset found for partition %d of topic %s", partition, topic)
+	}
+	if offsets[0].Error != nil {
+		return 0, offsets[0].Error
+	}
+	if offsets[0].Offset < 0 {
+		return int64(kafka.OffsetEnd), nil
+	}
+
+	return int64(offsets[0].Offset), nil
 }
 
-func (client *KafkaClient) ConsumerPull() (KafkaMessage, kafka.Event) {
-	var message KafkaMessage
+// ConsumerPull polls the next message. It returns a nil message when the
+// poll timed out or yielded an event other than a message.
+func (client *KafkaClient) ConsumerPull() (*ConsumedMessage, error) {
+	if client.workers != nil {
+		msg, err := client.workers.pull(client)
+		client.consumed(msg)
+		return msg, err
+	}
+	if client.Consumer == nil {
+		return nil, ErrNoConsumer
+	}
 	ev := client.Consumer.Poll(100)
 
 	if ev == nil {
-		return message, ev
+		return nil, nil
 	}
 
 	switch e := ev.(type) {
 	case *kafka.Message:
-		json.Unmarshal([]byte(e.Value), &message.Value)
-		message.Offset = e.TopicPartition.Offset
-		message.Timestamp = e.Timestamp
+		msg := client.newConsumedMessage(e)
+		client.consumed(msg)
+		return msg, nil
 	case kafka.Error:
-		fmt.Fprintf(os.Stderr, "%% Error: %v: %v\n", e.Code(), e)
-		if e.Code() == kafka.ErrAllBrokersDown {
-			panic(e)
+		// Logged by the caller, along with the context of the stream.
+		return nil, e
+	case kafka.AssignedPartitions, kafka.RevokedPartitions:
+		// The rebalances are handled by the callback of the subscription,
+		// unless they are delivered as events.
+		return nil, client.rebalance(client.Consumer, e)
+	case kafka.OffsetsCommitted:
+		if e.Error != nil {
+			return nil, fmt.Errorf("error committing offsets: %w", e.Error)
+		}
+	case *kafka.Stats:
+		stats, err := parseStats(e.String())
+		if err != nil {
+			return nil, fmt.Errorf("error parsing statistics: %w", err)
+		}
+		client.statsConsumer = stats.Consumer
+		client.Stats.record(stats)
+	case kafka.PartitionEOF:
+		if client.FromBeginning && e.Topic != nil {
+			if client.endReached == nil {
+				client.endReached = make(map[partitionKey]bool)
+			}
+			client.endReached[partitionKey{*e.Topic, e.Partition}] = true
 		}
-	default:
 	}
-	return message, ev
+	return nil, nil
 }
 
-func (client KafkaClient) HealthCheck() error {
-	client.consumerInitialize()
+// consumed records the offset to resume the partition of the message from.
+func (client *KafkaClient) consumed(msg *ConsumedMessage) {
+	if msg == nil {
+		return
+	}
+	if client.resumeOffsets == nil {
+		client.resumeOffsets = make(map[partitionKey]int64)
+	}
+	client.resumeOffsets[partitionKey{msg.Topic, msg.Partition}] = msg.Offset + 1
+}
+
+// assignEnd records the offset the partition ends at with an end time: the
+// offset of its first message timed after the end, or its high watermark when
+// there is none yet.
+func (client *KafkaClient) assignEnd(topic string, partition int32, start int64) error {
+	if client.EndTime.IsZero() {
+		return nil
+	}
+	end, err := client.offsetForTime(topic, partition, client.EndTime.Add(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	if end == int64(kafka.OffsetEnd) {
+		if _, end, err = client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs()); err != nil {
+			return err
+		}
+	}
+	if client.endOffsets == nil {
+		client.endOffsets = make(map[partitionKey]offsetRange)
+	}
+	client.endOffsets[partitionKey{topic, partition}] = offsetRange{start, end}
+
+	return nil
+}
 
-	_, err := client.Consumer.GetMetadata(nil, true, int(client.HealthcheckTimeout))
+// Ended tells whether the partitions assigned with an end time were consumed
+// up to their end offset. The partitions starting at the latest offset have
+// no message before the end to consume.
+func (client *KafkaClient) Ended() bool {
+	if len(client.endOffsets) == 0 {
+		return false
+	}
+	for key, offsets := range client.endOffsets {
+		if offsets.start == int64(kafka.OffsetEnd) {
+			continue
+		}
+		reached, exists := client.resumeOffsets[key]
+		if !exists {
+			reached = offsets.start
+		}
+		if reached < offsets.end {
+			return false
+		}
+	}
+	return true
+}
+
+// HistoryRead tells whether the consumer reached the end of every partition
+// assigned to it, reading from the beginning.
+func (client *KafkaClient) HistoryRead() bool {
+	if client.workers != nil {
+		return client.workers.historyRead(client)
+	}
+	if client.Consumer == nil {
+		return false
+	}
+	assignment, err := client.Consumer.Assignment()
+	if err != nil || len(assignment) == 0 {
+		return false
+	}
+	for _, partition := range assignment {
+		if partition.Topic == nil || !client.endReached[partitionKey{*partition.Topic, partition.Partition}] {
+			return false
+		}
+	}
+	return true
+}
+
+func (client *KafkaClient) newConsumedMessage(e *kafka.Message) *ConsumedMessage {
+	message := &ConsumedMessage{
+		Key:       e.Key,
+		Value:     e.Value,
+		Headers:   make(map[string]string, len(e.Headers)),
+		Partition: e.TopicPartition.Partition,
+		Offset:    int64(e.TopicPartition.Offset),
+		Timestamp: e.Timestamp,
+	}
+	if e.TopicPartition.Topic != nil {
+		message.Topic = *e.TopicPartition.Topic
+	}
+	for _, header := range e.Headers {
+		message.Headers[header.Key] = string(header.Value)
+	}
+	data := e.Value
+	if client.Decode.FromKey {
+		// The value of the messages of key-only topics is null, which isn't
+		// a deletion.
+		data = e.Key
+		if data == nil {
+			return message
+		}
+	} else if e.Value == nil {
+		record := map[string]interface{}{TOMBSTONE_FIELD: true}
+		if e.Key != nil {
+			record[TOMBSTONE_KEY_FIELD] = string(e.Key)
+		}
+		message.Values, message.Tombstone = []map[string]interface{}{record}, true
+		return message
+	}
+	if client.Decode.Format == FORMAT_PROTOBUF_SR {
+		record, err := client.SchemaRegistry.DecodeProtobuf(data)
+		message.Values, message.DecodeError = []map[string]interface{}{record}, err
+		return message
+	}
+	if client.Decode.StripSchemaPrefix {
+		var err error
+		if data, err = stripSchemaPrefix(data); err != nil {
+			message.DecodeError = err
+			return message
+		}
+	}
+	// The raw bytes are kept as is by the binary formats.
+	value := data
+	if isTranscoded(client.Decode.Format, data) {
+		var err error
+		value, err = transcode(data, client.charset, client.InvalidCharset)
+		if err != nil {
+			message.DecodeError = err
+			return message
+		}
+	}
+	message.Values, message.DecodeError = decodeValue(value, client.Decode)
+
+	return message
+}
+
+// RoundTrip produces a message to HEALTHCHECK_TOPIC and consumes it back,
+// checking that both producing and consuming work end to end.
+func (client KafkaClient) RoundTrip() error {
+	config := client.connectionConfig()
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	topic := HEALTHCHECK_TOPIC
+	payload := []byte(fmt.Sprintf("grafana-healthcheck-%d", time.Now().UnixNano()))
+	deliveries := make(chan kafka.Event, 1)
+	err = producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          payload,
+	}, deliveries)
+	if err != nil {
+		return fmt.Errorf("error producing to %s: %w", topic, err)
+	}
+
+	var delivered *kafka.Message
+	select {
+	case ev := <-deliveries:
+		delivered, _ = ev.(*kafka.Message)
+	case <-time.After(READ_TIMEOUT):
+		return fmt.Errorf("the message produced to %s wasn't delivered in %s", topic, READ_TIMEOUT)
+	}
+	if delivered == nil {
+		return fmt.Errorf("unexpected delivery report producing to %s", topic)
+	}
+	if delivered.TopicPartition.Error != nil {
+		return fmt.Errorf("error producing to %s: %w", topic, delivered.TopicPartition.Error)
+	}
+
+	if err := client.boundedConsumerInitialize(); err != nil {
+		return err
+	}
+	defer client.Dispose()
+
+	offset := int64(delivered.TopicPartition.Offset)
+	read, err := client.readRange(topic, delivered.TopicPartition.Partition, offset, offset)
+	if err != nil {
+		return fmt.Errorf("error consuming from %s: %w", topic, err)
+	}
+	if len(read) != 1 || !bytes.Equal(read[0].Value, payload) {
+		return fmt.Errorf("the message produced to %s couldn't be consumed back", topic)
+	}
+
+	return nil
+}
+
+// HealthCheck fetches the cluster metadata. When a topic is given, only its
+// metadata is requested, which works with ACLs restricted to that topic.
+func (client KafkaClient) HealthCheck(topic string) error {
+	release, err := client.sharedConsumerInitialize()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if topic != "" {
+		metadata, err := client.getMetadata(&topic)
+		if err != nil {
+			if authErr := client.probeAuthError(); authErr != nil {
+				return authErr
+			}
+			return err
+		}
+		if topicMetadata, exists := metadata.Topics[topic]; exists && topicMetadata.Error.Code() != kafka.ErrNoError {
+			return topicMetadata.Error
+		}
+		return nil
+	}
+
+	_, err = client.getMetadata(nil)
 
 	if err != nil {
-		if err.(kafka.Error).Code() == kafka.ErrTransport {
+		// Authentication failures are reported asynchronously as error
+		// events, while the metadata request itself merely fails.
+		if authErr := client.probeAuthError(); authErr != nil {
+			return authErr
+		}
+		if IsAuthError(err) {
+			return err
+		}
+		if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrTransport {
 			return err
 		}
 	}
@@ -162,6 +1335,372 @@ func (client KafkaClient) HealthCheck() error {
 	return nil
 }
 
+// probeAuthError requests the metadata with a consumer of its own, whose
+// error events can be polled without taking those of a shared consumer.
+func (client KafkaClient) probeAuthError() error {
+	if err := client.consumerInitialize(); err != nil {
+		return nil
+	}
+	defer client.Dispose()
+
+	if _, err := client.Consumer.GetMetadata(nil, false, client.metadataTimeoutMs()); err == nil {
+		return nil
+	}
+	return client.pendingAuthError()
+}
+
+func (client *KafkaClient) pendingAuthError() error {
+	for {
+		ev := client.Consumer.Poll(0)
+		if ev == nil {
+			return nil
+		}
+		if err, ok := ev.(kafka.Error); ok && IsAuthError(err) {
+			return err
+		}
+	}
+}
+
+// IsAuthenticationError tells whether the brokers rejected the credentials.
+func IsAuthenticationError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	if !ok {
+		return false
+	}
+	switch kafkaErr.Code() {
+	case kafka.ErrAuthentication, kafka.ErrSaslAuthenticationFailed,
+		kafka.ErrUnsupportedSaslMechanism, kafka.ErrIllegalSaslState:
+		return true
+	}
+	return false
+}
+
+// IsAuthorizationError tells whether the ACLs denied the operation.
+func IsAuthorizationError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	if !ok {
+		return false
+	}
+	switch kafkaErr.Code() {
+	case kafka.ErrTopicAuthorizationFailed, kafka.ErrGroupAuthorizationFailed,
+		kafka.ErrClusterAuthorizationFailed:
+		return true
+	}
+	return false
+}
+
+// IsTopicAuthorizationError tells whether the ACLs denied reading the topic.
+func IsTopicAuthorizationError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	return ok && kafkaErr.Code() == kafka.ErrTopicAuthorizationFailed
+}
+
+func IsUnknownTopicError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	return ok && (kafkaErr.Code() == kafka.ErrUnknownTopicOrPart || kafkaErr.Code() == kafka.ErrUnknownTopic)
+}
+
+// IsAutoOffsetResetError tells whether a partition had no committed offset to
+// resume from with AUTO_OFFSET_RESET_ERROR.
+func IsAutoOffsetResetError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	return ok && kafkaErr.Code() == kafka.ErrAutoOffsetReset
+}
+
+// IsAllBrokersDownError tells whether the consumer lost the connection to
+// every broker of the cluster.
+func IsAllBrokersDownError(err error) bool {
+	kafkaErr, ok := err.(kafka.Error)
+	return ok && kafkaErr.Code() == kafka.ErrAllBrokersDown
+}
+
+func IsAuthError(err error) bool {
+	return IsAuthenticationError(err) || IsAuthorizationError(err)
+}
+
+// Preview reads count messages of a partition from an offset, or the last
+// count messages when the offset is negative, with a dedicated consumer,
+// returning both their raw bytes and the decoded JSON.
+func (client KafkaClient) Preview(topic string, partition int32, from int64, count int64) ([]PreviewMessage, error) {
+	if err := client.boundedConsumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Dispose()
+
+	low, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs())
+	if err != nil {
+		return nil, err
+	}
+
+	offset := from
+	if offset < 0 {
+		offset = high - count
+	}
+	if offset < low {
+		offset = low
+	}
+	to := offset + count - 1
+	if to >= high {
+		to = high - 1
+	}
+	read, err := client.readRange(topic, partition, offset, to)
+
+	messages := make([]PreviewMessage, 0, len(read))
+	for _, e := range read {
+		message := PreviewMessage{
+			Offset:    int64(e.TopicPartition.Offset),
+			Timestamp: e.Timestamp,
+			Key:       e.Key,
+			Raw:       e.Value,
+		}
+		if e.Value == nil {
+			message.Tombstone = true
+			messages = append(messages, message)
+			continue
+		}
+		value, err := transcode(e.Value, client.charset, client.InvalidCharset)
+		if err == nil {
+			err = json.Unmarshal(value, &message.Value)
+		}
+		if err != nil {
+			message.DecodeError = err.Error()
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, err
+}
+
+// ReadRange reads and decodes the messages of a partition from an offset to
+// another, both inclusive, stopping early at the end of the partition. The
+// messages read before a timeout are returned along with ErrReadTimeout.
+func (client KafkaClient) ReadRange(topic string, partition int32, from int64, to int64) ([]*ConsumedMessage, error) {
+	if err := client.boundedConsumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Dispose()
+
+	_, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition, client.metadataTimeoutMs())
+	if err != nil {
+		return nil, err
+	}
+	if to >= high {
+		to = high - 1
+	}
+	read, err := client.readRange(topic, partition, from, to)
+
+	messages := make([]*ConsumedMessage, len(read))
+	for i, e := range read {
+		messages[i] = client.newConsumedMessage(e)
+	}
+
+	return messages, err
+}
+
+// TopicInfo describes a topic of the cluster. Topics whose name starts with
+// an underscore, like __consumer_offsets, are internal by convention.
+type TopicInfo struct {
+	Name              string `json:"name"`
+	Partitions        int    `json:"partitions"`
+	ReplicationFactor int    `json:"replicationFactor"`
+	Internal          bool   `json:"internal"`
+}
+
+// IsInternalTopic tells whether the topic is internal to Kafka or to a tool of
+// its ecosystem, like __consumer_offsets, _schemas or the _confluent- topics,
+// whose records are usually binary. The other topics starting with a single
+// underscore, like _audit, are the ones of the users.
+func IsInternalTopic(topic string) bool {
+	return strings.HasPrefix(topic, "__") || topic == "_schemas" || strings.HasPrefix(topic, "_confluent-")
+}
+
+// Topics lists the topics of the cluster, ordered by name.
+func (client KafkaClient) Topics() ([]TopicInfo, error) {
+	release, err := client.sharedConsumerInitialize()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	metadata, err := client.getMetadata(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]TopicInfo, 0, len(metadata.Topics))
+	for name, topicMetadata := range metadata.Topics {
+		topic := TopicInfo{
+			Name:       name,
+			Partitions: len(topicMetadata.Partitions),
+			Internal:   IsInternalTopic(name),
+		}
+		for _, partition := range topicMetadata.Partitions {
+			if len(partition.Replicas) > topic.ReplicationFactor {
+				topic.ReplicationFactor = len(partition.Replicas)
+			}
+		}
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+
+	return topics, nil
+}
+
+// PartitionOffsets holds the low and high watermark offsets of a partition.
+type PartitionOffsets struct {
+	Partition int32
+	Low       int64
+	High      int64
+}
+
+// WatermarkOffsets returns the watermark offsets of every partition of the
+// topic, ordered by partition.
+func (client KafkaClient) WatermarkOffsets(topic string) ([]PartitionOffsets, error) {
+	release, err := client.sharedConsumerInitialize()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return client.watermarkOffsets(topic)
+}
+
+func (client *KafkaClient) watermarkOffsets(topic string) ([]PartitionOffsets, error) {
+	metadata, err := client.getMetadata(&topic)
+	if err != nil {
+		return nil, err
+	}
+	topicMetadata, exists := metadata.Topics[topic]
+	if !exists {
+		return nil, kafka.NewError(kafka.ErrUnknownTopic, fmt.Sprintf("topic %s not found", topic), false)
+	}
+	if topicMetadata.Error.Code() != kafka.ErrNoError {
+		return nil, topicMetadata.Error
+	}
+
+	offsets := make([]PartitionOffsets, 0, len(topicMetadata.Partitions))
+	for _, partition := range topicMetadata.Partitions {
+		low, high, err := client.Consumer.QueryWatermarkOffsets(topic, partition.ID, client.metadataTimeoutMs())
+		if err != nil {
+			return nil, fmt.Errorf("error querying the offsets of partition %d: %w", partition.ID, err)
+		}
+		offsets = append(offsets, PartitionOffsets{Partition: partition.ID, Low: low, High: high})
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Partition < offsets[j].Partition
+	})
+
+	return offsets, nil
+}
+
+// ReadSnapshot reads and decodes the messages of a partition, or of every
+// partition with ALL_PARTITIONS, from the earliest offset up to the high
+// watermark at the time of the call, ignoring the messages produced while
+// reading. The partitions timing out are read in part, and reported by an
+// ErrReadTimeout once the others are read.
+func (client KafkaClient) ReadSnapshot(topic string, partition int32) ([]*ConsumedMessage, error) {
+	if err := client.boundedConsumerInitialize(); err != nil {
+		return nil, err
+	}
+	defer client.Dispose()
+
+	offsets, err := client.watermarkOffsets(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*ConsumedMessage
+	var timedOut []string
+	for _, offset := range offsets {
+		if partition != ALL_PARTITIONS && offset.Partition != partition {
+			continue
+		}
+		read, err := client.readRange(topic, offset.Partition, offset.Low, offset.High-1)
+		for _, e := range read {
+			messages = append(messages, client.newConsumedMessage(e))
+		}
+		if errors.Is(err, ErrReadTimeout) {
+			timedOut = append(timedOut, fmt.Sprint(offset.Partition))
+			continue
+		}
+		if err != nil {
+			return messages, fmt.Errorf("error reading partition %d: %w", offset.Partition, err)
+		}
+	}
+	if len(timedOut) > 0 {
+		return messages, fmt.Errorf("%w: partitions %s read in part", ErrReadTimeout, strings.Join(timedOut, ", "))
+	}
+
+	return messages, nil
+}
+
+// readRange reads the messages of a partition from an offset to another,
+// both inclusive, stopping at the end of the partition when the consumer
+// reports it. It gives up after READ_TIMEOUT with ErrReadTimeout.
+func (client *KafkaClient) readRange(topic string, partition int32, from int64, to int64) ([]*kafka.Message, error) {
+	var messages []*kafka.Message
+	if from > to {
+		return messages, nil
+	}
+
+	err := client.Consumer.Assign([]kafka.TopicPartition{{
+		Topic:     &topic,
+		Partition: partition,
+		Offset:    kafka.Offset(from),
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(READ_TIMEOUT)
+	for time.Now().Before(deadline) {
+		switch e := client.Consumer.Poll(100).(type) {
+		case *kafka.Message:
+			offset := int64(e.TopicPartition.Offset)
+			if offset > to {
+				return messages, nil
+			}
+			messages = append(messages, e)
+			if offset == to {
+				return messages, nil
+			}
+		case kafka.PartitionEOF:
+			return messages, nil
+		case kafka.Error:
+			return messages, e
+		}
+	}
+
+	return messages, fmt.Errorf("%w after %s, %d messages read up to offset %d",
+		ErrReadTimeout, READ_TIMEOUT, len(messages), to)
+}
+
+// LibraryVersion returns the version of the librdkafka library linked in.
+func LibraryVersion() string {
+	_, version := kafka.LibraryVersion()
+	return version
+}
+
 func (client *KafkaClient) Dispose() {
-	client.Consumer.Close()
+	client.Stats.remove(client.statsConsumer)
+	client.statsConsumer = ""
+	if client.workers != nil {
+		client.workers.close()
+		client.workers = nil
+	}
+	if client.Consumer != nil {
+		client.Consumer.Close()
+		client.Consumer = nil
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
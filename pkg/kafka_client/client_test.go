@@ -0,0 +1,145 @@
+package kafka_client_test
+
+import (
+	"testing"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options kafka_client.Options
+		valid   bool
+	}{
+		{"defaults", kafka_client.Options{}, true},
+		{"lowercase protocol", kafka_client.Options{SecurityProtocol: "sasl_ssl"}, true},
+		{"unknown protocol", kafka_client.Options{SecurityProtocol: "TLS"}, false},
+		{"heartbeat too close to session timeout", kafka_client.Options{SessionTimeoutMs: 6000, HeartbeatIntervalMs: 3000}, false},
+		{"tuned timeouts", kafka_client.Options{SessionTimeoutMs: 60000, HeartbeatIntervalMs: 5000}, true},
+		{"long max poll interval", kafka_client.Options{MaxPollIntervalMs: 900000}, true},
+		{"max poll interval below session timeout", kafka_client.Options{SessionTimeoutMs: 60000, MaxPollIntervalMs: 30000}, false},
+		{"max poll interval beyond a day", kafka_client.Options{MaxPollIntervalMs: 86400001}, false},
+		{"short fetch wait", kafka_client.Options{FetchWaitMaxMs: 10}, true},
+		{"negative fetch wait", kafka_client.Options{FetchWaitMaxMs: -1}, false},
+		{"fetch wait beyond the socket timeout", kafka_client.Options{FetchWaitMaxMs: 60000}, false},
+		{"long fetch wait with a long socket timeout", kafka_client.Options{FetchWaitMaxMs: 60000, SocketTimeoutMs: 61000}, true},
+		{"unknown auto offset reset", kafka_client.Options{AutoOffsetReset: "beginning"}, false},
+		{"error auto offset reset", kafka_client.Options{AutoOffsetReset: "error"}, true},
+		{"ipv6 only", kafka_client.Options{BrokerAddressFamily: "v6"}, true},
+		{"unknown address family", kafka_client.Options{BrokerAddressFamily: "ipv6"}, false},
+		{"negative reconnect attempts", kafka_client.Options{MaxReconnectAttempts: -1}, false},
+		{"negative max concurrent streams", kafka_client.Options{MaxConcurrentStreams: -1}, false},
+		{"statistics every 15s", kafka_client.Options{StatisticsIntervalMs: 15000}, true},
+		{"statistics beyond a day", kafka_client.Options{StatisticsIntervalMs: 86400001}, false},
+		{"bounded prefetch", kafka_client.Options{QueuedMaxMessagesKbytes: 16384, QueuedMinMessages: 1000}, true},
+		{"prefetch beyond the librdkafka limit", kafka_client.Options{QueuedMaxMessagesKbytes: 4194304}, false},
+		{"reconnect backoffs", kafka_client.Options{ReconnectBackoffMs: 500, ReconnectBackoffMaxMs: 30000}, true},
+		{"maximum backoff below the backoff", kafka_client.Options{ReconnectBackoffMs: 5000, ReconnectBackoffMaxMs: 1000}, false},
+		{"pasted servers", kafka_client.Options{BootstrapServers: "kafka://broker1:9092, broker2:9092,"}, true},
+		{"server without port", kafka_client.Options{BootstrapServers: "broker1"}, true},
+		{"ipv6 server", kafka_client.Options{BootstrapServers: "[::1]:9092"}, true},
+		{"invalid port", kafka_client.Options{BootstrapServers: "broker1:9092,broker2:90920"}, false},
+		{"missing host", kafka_client.Options{BootstrapServers: ":9092"}, false},
+		{"system CA", kafka_client.Options{SecurityProtocol: "SASL_SSL", TlsCaMode: "system"}, true},
+		{"unknown CA mode", kafka_client.Options{SecurityProtocol: "SSL", TlsCaMode: "file"}, false},
+		{"provided CA without certificate", kafka_client.Options{SecurityProtocol: "SSL", TlsCaMode: "provided"}, false},
+		{"provided CA", kafka_client.Options{SecurityProtocol: "SSL", TlsCaMode: "provided",
+			TlsCaCert: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"}, true},
+		{"group instance id", kafka_client.Options{GroupInstanceId: "grafana-1"}, true},
+		{"invalid group instance id", kafka_client.Options{GroupInstanceId: "grafana 1"}, false},
+		{"API key", kafka_client.Options{APIKey: "key", APISecret: "secret"}, true},
+		{"API key without secret", kafka_client.Options{APIKey: "key"}, false},
+		{"API key without TLS", kafka_client.Options{APIKey: "key", APISecret: "secret", SecurityProtocol: "SASL_PLAINTEXT"}, false},
+		{"API key with SASL username", kafka_client.Options{APIKey: "key", APISecret: "secret", SaslUsername: "user"}, false},
+		{"API key with SASL password", kafka_client.Options{APIKey: "key", APISecret: "secret", SaslPassword: "password"}, false},
+		{"API key with SCRAM", kafka_client.Options{APIKey: "key", APISecret: "secret", SaslMechanisms: "SCRAM-SHA-512"}, false},
+	}
+
+	for _, test := range tests {
+		options := test.options
+		options.ApplyDefaults()
+		err := options.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestOptionsNormalizeBootstrapServers(t *testing.T) {
+	options := kafka_client.Options{BootstrapServers: " kafka://broker1:9092/, SASL_SSL://broker2:9093,, "}
+	options.ApplyDefaults()
+
+	if options.BootstrapServers != "broker1:9092,broker2:9093" {
+		t.Errorf("unexpected servers %q", options.BootstrapServers)
+	}
+}
+
+func TestOptionsAPIKey(t *testing.T) {
+	options := kafka_client.Options{APIKey: "key", APISecret: "secret"}
+	options.ApplyDefaults()
+
+	if options.SecurityProtocol != "SASL_SSL" || options.SaslMechanisms != "PLAIN" ||
+		options.SaslUsername != "key" || options.SaslPassword != "secret" {
+		t.Errorf("expected the API key as SASL PLAIN credentials over SASL_SSL, got %+v", options)
+	}
+}
+
+func TestOptionsQueryBootstrapServers(t *testing.T) {
+	options := kafka_client.Options{AllowedBootstrapServers: "other1:9092, other2:9092"}
+	options.ApplyDefaults()
+
+	if servers, err := options.QueryBootstrapServers("kafka://other2:9092,"); err != nil || servers != "other2:9092" {
+		t.Errorf("expected an allowed server, got %q, %v", servers, err)
+	}
+	if _, err := options.QueryBootstrapServers("other1:9092,attacker:9092"); err == nil {
+		t.Error("expected the servers which aren't allowed to be refused")
+	}
+	if _, err := (kafka_client.Options{}).QueryBootstrapServers("other1:9092"); err == nil {
+		t.Error("expected the overrides to be refused without allowed servers")
+	}
+}
+
+func TestOptionsRedacted(t *testing.T) {
+	options := kafka_client.Options{BootstrapServers: "broker:9092", APIKey: "key", APISecret: "secret"}
+	options.ApplyDefaults()
+
+	redacted := options.Redacted()
+	if redacted.APIKey != kafka_client.REDACTED || redacted.APISecret != kafka_client.REDACTED ||
+		redacted.SaslUsername != kafka_client.REDACTED || redacted.SaslPassword != kafka_client.REDACTED {
+		t.Errorf("expected the credentials masked, got %+v", redacted)
+	}
+	if redacted.BootstrapServers != "broker:9092" || redacted.TlsCaCert != "" {
+		t.Errorf("expected the other settings left as is, got %+v", redacted)
+	}
+	if options.APISecret != "secret" {
+		t.Error("expected the options left unchanged")
+	}
+
+	client := kafka_client.NewKafkaClient(redacted)
+	config := client.ConsumerConfig()
+	if config["sasl.password"] != kafka_client.REDACTED || config["bootstrap.servers"] != "broker:9092" {
+		t.Errorf("expected the consumer config of the redacted options, got %v", config)
+	}
+}
+
+func TestIsInternalTopic(t *testing.T) {
+	for topic, internal := range map[string]bool{
+		"__consumer_offsets":  true,
+		"__transaction_state": true,
+		"_schemas":            true,
+		"_confluent-metrics":  true,
+		"_confluent-command":  true,
+		"_audit":              false,
+		"_schemas-backup":     false,
+		"orders":              false,
+		"orders__internal":    false,
+	} {
+		if kafka_client.IsInternalTopic(topic) != internal {
+			t.Errorf("%s: expected internal %v", topic, internal)
+		}
+	}
+}
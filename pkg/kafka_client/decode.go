@@ -0,0 +1,300 @@
+package kafka_client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const FORMAT_JSON = "json"
+const FORMAT_JSON_ARRAY = "jsonarray"
+const FORMAT_NDJSON = "ndjson"
+const FORMAT_CSV = "csv"
+
+// Messages of the Confluent protobuf serializer, decoded with the schemas
+// of the schema registry.
+const FORMAT_PROTOBUF_SR = "protobuf-sr"
+
+// The raw bytes of the messages, encoded into a single value field.
+const FORMAT_BASE64 = "base64"
+const FORMAT_HEX = "hex"
+
+// The text of the messages as a single value field.
+const FORMAT_STRING = "string"
+
+// MessagePack maps, decoded like the JSON objects.
+const FORMAT_MSGPACK = "msgpack"
+
+// XML documents, their elements decoded like the JSON objects.
+const FORMAT_XML = "xml"
+
+var FORMATS = []string{FORMAT_JSON, FORMAT_JSON_ARRAY, FORMAT_NDJSON, FORMAT_CSV, FORMAT_PROTOBUF_SR, FORMAT_BASE64, FORMAT_HEX, FORMAT_STRING, FORMAT_MSGPACK, FORMAT_XML}
+
+const DEFAULT_CHARSET = "utf-8"
+
+// Policies for the byte sequences which are invalid in the charset.
+const INVALID_CHARSET_REPLACE = "replace"
+const INVALID_CHARSET_SKIP = "skip"
+const INVALID_CHARSET_ERROR = "error"
+
+var INVALID_CHARSET_POLICIES = []string{INVALID_CHARSET_REPLACE, INVALID_CHARSET_SKIP, INVALID_CHARSET_ERROR}
+
+// isBinaryFormat tells whether the format decodes bytes rather than text,
+// which isn't transcoded.
+func isBinaryFormat(format string) bool {
+	return format == FORMAT_BASE64 || format == FORMAT_HEX || format == FORMAT_MSGPACK
+}
+
+// isTranscoded tells whether a value of the format is transcoded from the
+// charset of the datasource. The XML documents declaring their encoding are
+// transcoded by the XML decoder instead.
+func isTranscoded(format string, value []byte) bool {
+	if format == FORMAT_XML {
+		return !declaresXMLEncoding(value)
+	}
+	return !isBinaryFormat(format)
+}
+
+// lookupCharset returns the encoding of the charset, or nil for UTF-8 which
+// needs no transcoding.
+func lookupCharset(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == DEFAULT_CHARSET || name == "utf8" {
+		return nil, nil
+	}
+	charset, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", name, err)
+	}
+	return charset, nil
+}
+
+// transcode converts a message value from the charset to UTF-8, applying the
+// policy to the invalid sequences, which decode to the replacement character.
+func transcode(value []byte, charset encoding.Encoding, policy string) ([]byte, error) {
+	if charset == nil {
+		return value, nil
+	}
+	decoded, err := charset.NewDecoder().Bytes(value)
+	if err != nil {
+		return nil, err
+	}
+	if !hasInvalidSequences(value, decoded, charset) {
+		return decoded, nil
+	}
+
+	switch policy {
+	case INVALID_CHARSET_ERROR:
+		return nil, fmt.Errorf("invalid byte sequence in the message value")
+	case INVALID_CHARSET_SKIP:
+		return bytes.ReplaceAll(decoded, []byte(string(utf8.RuneError)), nil), nil
+	default:
+		return decoded, nil
+	}
+}
+
+// hasInvalidSequences tells whether the decoding of the value replaced
+// invalid sequences. The decoders don't report them, they output the
+// replacement character, which the value may also hold when its charset
+// encodes it: the replacement characters decoded are then counted against
+// the ones encoded in the value.
+func hasInvalidSequences(value []byte, decoded []byte, charset encoding.Encoding) bool {
+	replacements := bytes.Count(decoded, []byte(string(utf8.RuneError)))
+	if replacements == 0 {
+		return false
+	}
+	encoded, err := charset.NewEncoder().Bytes([]byte(string(utf8.RuneError)))
+	if err != nil || len(encoded) == 0 {
+		return true
+	}
+	return bytes.Count(value, encoded) != replacements
+}
+
+// DecodeOptions describes how the message values of a stream are decoded.
+type DecodeOptions struct {
+	Format string
+	// Names of the CSV columns; columns without a name are called column1,
+	// column2, etc.
+	CSVHeader    []string
+	CSVDelimiter rune
+	// Decode the keys of the messages instead of their values, for the state
+	// topics whose keys are the data.
+	FromKey bool
+	// Drop the Confluent wire format prefix, the magic byte and the schema id,
+	// of the messages of the schema registry serializers, without fetching
+	// their schema.
+	StripSchemaPrefix bool
+	// Field of the JSON values which aren't objects, like a bare number,
+	// DEFAULT_SCALAR_FIELD when not set.
+	ScalarField string
+}
+
+const DEFAULT_SCALAR_FIELD = "value"
+
+// Length of the Confluent wire format prefix: a zero magic byte and the
+// 4-byte schema id.
+const SCHEMA_PREFIX_LENGTH = 5
+
+func stripSchemaPrefix(value []byte) ([]byte, error) {
+	if len(value) < SCHEMA_PREFIX_LENGTH || value[0] != 0 {
+		return nil, errors.New("not a schema registry message, the magic byte is missing")
+	}
+	return value[SCHEMA_PREFIX_LENGTH:], nil
+}
+
+// decodeValue decodes a message value into its records, one per frame row.
+func decodeValue(value []byte, options DecodeOptions) ([]map[string]interface{}, error) {
+	switch options.Format {
+	case FORMAT_CSV:
+		record, err := decodeCSV(value, options)
+		return []map[string]interface{}{record}, err
+	case FORMAT_JSON_ARRAY:
+		return decodeJSONArray(value)
+	case FORMAT_NDJSON:
+		return decodeNDJSON(value, options.ScalarField)
+	case FORMAT_BASE64:
+		return []map[string]interface{}{{"value": base64.StdEncoding.EncodeToString(value)}}, nil
+	case FORMAT_HEX:
+		return []map[string]interface{}{{"value": hex.EncodeToString(value)}}, nil
+	case FORMAT_STRING:
+		return []map[string]interface{}{{"value": string(value)}}, nil
+	case FORMAT_MSGPACK:
+		record, err := decodeMsgpack(value)
+		return []map[string]interface{}{record}, err
+	case FORMAT_XML:
+		record, err := decodeXML(value)
+		return []map[string]interface{}{record}, err
+	default:
+		record, err := decodeJSONValue(value, options.ScalarField)
+		return []map[string]interface{}{record}, err
+	}
+}
+
+// decodeJSONValue decodes a JSON object, or a scalar value into a record of
+// a single field.
+func decodeJSONValue(value []byte, scalarField string) (map[string]interface{}, error) {
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return map[string]interface{}{}, err
+	}
+
+	switch v := decoded.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		flatten("", v, out)
+		return out, nil
+	case []interface{}:
+		return map[string]interface{}{}, errors.New("expected a JSON object or scalar, got an array")
+	default:
+		if scalarField == "" {
+			scalarField = DEFAULT_SCALAR_FIELD
+		}
+		return map[string]interface{}{scalarField: v}, nil
+	}
+}
+
+func decodeJSON(value []byte) (map[string]interface{}, error) {
+	var decoded map[string]interface{}
+	err := json.Unmarshal(value, &decoded)
+	out := make(map[string]interface{}, len(decoded))
+	flatten("", decoded, out)
+
+	return out, err
+}
+
+// decodeJSONArray decodes a message packing its records in a JSON array.
+func decodeJSONArray(value []byte) ([]map[string]interface{}, error) {
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return nil, err
+	}
+
+	records := make([]map[string]interface{}, len(decoded))
+	for i, record := range decoded {
+		records[i] = make(map[string]interface{}, len(record))
+		flatten("", record, records[i])
+	}
+
+	return records, nil
+}
+
+// decodeNDJSON decodes a message holding a JSON object per line.
+func decodeNDJSON(value []byte, scalarField string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	for i, line := range bytes.Split(value, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record, err := decodeJSONValue(line, scalarField)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// flatten copies the nested objects of a decoded message into a single map
+// with dot-separated keys, e.g. {"metrics":{"cpu":1}} becomes "metrics.cpu".
+func flatten(prefix string, value map[string]interface{}, out map[string]interface{}) {
+	for key, v := range value {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(key, nested, out)
+		} else {
+			out[key] = v
+		}
+	}
+}
+
+// decodeCSV maps a single CSV row to the header columns, keeping the
+// numeric cells as numbers and the others as strings.
+func decodeCSV(value []byte, options DecodeOptions) (map[string]interface{}, error) {
+	record, err := SplitCSV(string(value), options.CSVDelimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{}, len(record))
+	for i, cell := range record {
+		name := fmt.Sprintf("column%d", i+1)
+		if i < len(options.CSVHeader) && options.CSVHeader[i] != "" {
+			name = options.CSVHeader[i]
+		}
+		if number, err := strconv.ParseFloat(cell, 64); err == nil {
+			out[name] = number
+		} else {
+			out[name] = cell
+		}
+	}
+
+	return out, nil
+}
+
+// SplitCSV parses a single CSV row, honoring quoted cells.
+func SplitCSV(row string, delimiter rune) ([]string, error) {
+	reader := csv.NewReader(bytes.NewBufferString(row))
+	if delimiter != 0 {
+		reader.Comma = delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	return reader.Read()
+}
@@ -0,0 +1,137 @@
+package kafka_client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	options := DecodeOptions{
+		Format:       FORMAT_CSV,
+		CSVHeader:    []string{"host", "load"},
+		CSVDelimiter: ';',
+	}
+
+	records, err := decodeValue([]byte(`"web;1";0.5;up`), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{{"host": "web;1", "load": 0.5, "column3": "up"}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v, got %v", expected, records)
+	}
+}
+
+func TestDecodeMultipleRecords(t *testing.T) {
+	expected := []map[string]interface{}{{"a": 1.0}, {"a": 2.0, "b.c": "x"}}
+
+	records, err := decodeValue([]byte(`[{"a":1},{"a":2,"b":{"c":"x"}}]`), DecodeOptions{Format: FORMAT_JSON_ARRAY})
+	if err != nil || !reflect.DeepEqual(records, expected) {
+		t.Errorf("jsonarray: expected %v, got %v (%v)", expected, records, err)
+	}
+
+	records, err = decodeValue([]byte("{\"a\":1}\n\n{\"a\":2,\"b\":{\"c\":\"x\"}}\n"), DecodeOptions{Format: FORMAT_NDJSON})
+	if err != nil || !reflect.DeepEqual(records, expected) {
+		t.Errorf("ndjson: expected %v, got %v (%v)", expected, records, err)
+	}
+}
+
+func TestDecodeScalar(t *testing.T) {
+	records, err := decodeValue([]byte(" 42.5\n"), DecodeOptions{})
+	if err != nil || !reflect.DeepEqual(records, []map[string]interface{}{{"value": 42.5}}) {
+		t.Errorf("expected the number in a value field, got %v (%v)", records, err)
+	}
+
+	records, err = decodeValue([]byte(`"up"`), DecodeOptions{ScalarField: "status"})
+	if err != nil || !reflect.DeepEqual(records, []map[string]interface{}{{"status": "up"}}) {
+		t.Errorf("expected the string in the status field, got %v (%v)", records, err)
+	}
+
+	if _, err := decodeValue([]byte(`[1, 2]`), DecodeOptions{}); err == nil {
+		t.Error("expected an array to fail without the jsonarray format")
+	}
+}
+
+func TestDecodeRawBytes(t *testing.T) {
+	value := []byte{0xca, 0xfe, 0x01}
+
+	records, err := decodeValue(value, DecodeOptions{Format: FORMAT_HEX})
+	if err != nil || records[0]["value"] != "cafe01" {
+		t.Errorf("hex: unexpected %v (%v)", records, err)
+	}
+
+	records, err = decodeValue(value, DecodeOptions{Format: FORMAT_BASE64})
+	if err != nil || records[0]["value"] != "yv4B" {
+		t.Errorf("base64: unexpected %v (%v)", records, err)
+	}
+
+	records, err = decodeValue([]byte("user-42"), DecodeOptions{Format: FORMAT_STRING})
+	if err != nil || records[0]["value"] != "user-42" {
+		t.Errorf("string: unexpected %v (%v)", records, err)
+	}
+}
+
+func TestStripSchemaPrefix(t *testing.T) {
+	value, err := stripSchemaPrefix([]byte("\x00\x00\x00\x00\x2a{\"a\":1}"))
+	if err != nil || string(value) != `{"a":1}` {
+		t.Errorf("unexpected %q (%v)", value, err)
+	}
+
+	if _, err := stripSchemaPrefix([]byte(`{"a":1}`)); err == nil {
+		t.Errorf("expected a value without prefix to fail")
+	}
+}
+
+func TestTranscodeReplacementCharacter(t *testing.T) {
+	charset, err := lookupCharset("gb18030")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := charset.NewEncoder().String("a�b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := transcode([]byte(encoded), charset, INVALID_CHARSET_ERROR)
+	if err != nil || string(decoded) != "a�b" {
+		t.Errorf("expected the encoded replacement character to be kept, got %q, %v", decoded, err)
+	}
+	if _, err := transcode([]byte("a\xffb"), charset, INVALID_CHARSET_ERROR); err == nil {
+		t.Error("expected an invalid sequence to fail")
+	}
+}
+
+func TestConsumedMessageTombstone(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       []byte
+		value     []byte
+		fromKey   bool
+		tombstone bool
+		values    []map[string]interface{}
+	}{
+		{"tombstone", []byte("user-1"), nil, false, true,
+			[]map[string]interface{}{{TOMBSTONE_FIELD: true, TOMBSTONE_KEY_FIELD: "user-1"}}},
+		{"tombstone without key", nil, nil, false, true,
+			[]map[string]interface{}{{TOMBSTONE_FIELD: true}}},
+		{"empty value", []byte("user-1"), []byte{}, false, false, nil},
+		{"record", []byte("user-1"), []byte(`{"a":1}`), false, false,
+			[]map[string]interface{}{{"a": 1.0}}},
+		{"key-only topic", []byte(`{"a":1}`), nil, true, false,
+			[]map[string]interface{}{{"a": 1.0}}},
+	}
+
+	for _, test := range tests {
+		client := &KafkaClient{Decode: DecodeOptions{FromKey: test.fromKey}}
+		message := client.newConsumedMessage(&kafka.Message{Key: test.key, Value: test.value})
+		if message.Tombstone != test.tombstone {
+			t.Errorf("%s: expected tombstone %v", test.name, test.tombstone)
+		}
+		if test.values != nil && !reflect.DeepEqual(message.Values, test.values) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.values, message.Values)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package kafka_client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Default time to live of the cached broker metadata.
+const DEFAULT_METADATA_CACHE_TTL_MS int32 = 5000
+
+// Attempts to get the partitions of a topic the brokers don't know yet, like
+// one created moments ago, and the interval between them.
+const (
+	TOPIC_METADATA_ATTEMPTS       = 5
+	TOPIC_METADATA_RETRY_INTERVAL = 500 * time.Millisecond
+)
+
+// MetadataCache keeps the broker metadata for a short time, so that the
+// rapid requests of the config and query editors don't each query the
+// brokers. A nil cache caches nothing.
+type MetadataCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]metadataEntry
+}
+
+type metadataEntry struct {
+	metadata *kafka.Metadata
+	expires  time.Time
+}
+
+func NewMetadataCache(ttl time.Duration) *MetadataCache {
+	return &MetadataCache{ttl: ttl, entries: make(map[string]metadataEntry)}
+}
+
+// get returns the metadata cached for the key, or nil when it expired.
+func (cache *MetadataCache) get(key string) *kafka.Metadata {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, exists := cache.entries[key]
+	if !exists || time.Now().After(entry.expires) {
+		delete(cache.entries, key)
+		return nil
+	}
+	return entry.metadata
+}
+
+func (cache *MetadataCache) put(key string, metadata *kafka.Metadata) {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries[key] = metadataEntry{metadata: metadata, expires: time.Now().Add(cache.ttl)}
+}
+
+// Invalidate drops all the cached metadata.
+func (cache *MetadataCache) Invalidate() {
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = make(map[string]metadataEntry)
+}
+
+// getMetadata returns the metadata of the topic, or of all the topics when
+// topic is nil, from the cache while it is fresh. The metadata of a topic
+// in error or without partitions isn't cached, so that a topic created
+// meanwhile is seen at once.
+func (client *KafkaClient) getMetadata(topic *string) (*kafka.Metadata, error) {
+	var key string
+	if topic != nil {
+		key = *topic
+	}
+	if metadata := client.MetadataCache.get(key); metadata != nil {
+		return metadata, nil
+	}
+
+	metadata, err := client.Consumer.GetMetadata(topic, topic == nil, client.metadataTimeoutMs())
+	if err != nil {
+		return nil, err
+	}
+	if topic != nil {
+		topicMetadata, exists := metadata.Topics[*topic]
+		if !exists || topicMetadata.Error.Code() != kafka.ErrNoError || len(topicMetadata.Partitions) == 0 {
+			return metadata, nil
+		}
+	}
+	client.MetadataCache.put(key, metadata)
+
+	return metadata, nil
+}
+
+// metadataTimeoutMs returns the timeout of the metadata and offsets requests,
+// falling back to the health check timeout set before it was configurable.
+func (client *KafkaClient) metadataTimeoutMs() int {
+	switch {
+	case client.MetadataTimeoutMs > 0:
+		return int(client.MetadataTimeoutMs)
+	case client.HealthcheckTimeout > 0:
+		return int(client.HealthcheckTimeout)
+	default:
+		return int(DEFAULT_METADATA_TIMEOUT_MS)
+	}
+}
+
+// topicMetadata returns the metadata of the topic assigned to a stream,
+// refreshing it for a short while when the topic isn't known yet or has no
+// partitions: the brokers learn of a topic some time after its creation, and
+// an offset assigned meanwhile never advances. The other operations don't
+// wait. It reports whether the topic was found, and stops waiting when the
+// context is done.
+func (client *KafkaClient) topicMetadata(ctx context.Context, topic string) (kafka.TopicMetadata, bool, error) {
+	for attempt := 1; ; attempt++ {
+		metadata, err := client.getMetadata(&topic)
+		if err != nil {
+			return kafka.TopicMetadata{}, false, err
+		}
+		topicMetadata, exists := metadata.Topics[topic]
+		if !topicPending(topicMetadata, exists) || attempt >= TOPIC_METADATA_ATTEMPTS {
+			return topicMetadata, exists, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return kafka.TopicMetadata{}, false, ctx.Err()
+		case <-time.After(TOPIC_METADATA_RETRY_INTERVAL):
+		}
+	}
+}
+
+// topicPending tells whether the metadata of a topic may still be missing
+// its partitions, rather than the topic being denied or broken.
+func topicPending(topicMetadata kafka.TopicMetadata, exists bool) bool {
+	if !exists {
+		return true
+	}
+	switch topicMetadata.Error.Code() {
+	case kafka.ErrNoError:
+		return len(topicMetadata.Partitions) == 0
+	case kafka.ErrUnknownTopicOrPart, kafka.ErrUnknownTopic, kafka.ErrLeaderNotAvailable:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,55 @@
+package kafka_client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestMetadataCache(t *testing.T) {
+	cache := NewMetadataCache(time.Hour)
+	cache.put("test", &kafka.Metadata{})
+	if cache.get("test") == nil {
+		t.Fatal("expected the metadata to be cached")
+	}
+
+	cache.Invalidate()
+	if cache.get("test") != nil {
+		t.Error("expected the cache to be invalidated")
+	}
+
+	expired := NewMetadataCache(-time.Second)
+	expired.put("test", &kafka.Metadata{})
+	if expired.get("test") != nil {
+		t.Error("expected the metadata to expire")
+	}
+
+	var disabled *MetadataCache
+	disabled.put("test", &kafka.Metadata{})
+	if disabled.get("test") != nil {
+		t.Error("expected a nil cache to cache nothing")
+	}
+}
+
+func TestTopicPending(t *testing.T) {
+	partitions := []kafka.PartitionMetadata{{ID: 0}}
+	cases := []struct {
+		name     string
+		metadata kafka.TopicMetadata
+		exists   bool
+		pending  bool
+	}{
+		{"missing", kafka.TopicMetadata{}, false, true},
+		{"ready", kafka.TopicMetadata{Partitions: partitions}, true, false},
+		{"no partitions", kafka.TopicMetadata{}, true, true},
+		{"unknown", kafka.TopicMetadata{Error: kafka.NewError(kafka.ErrUnknownTopicOrPart, "", false)}, true, true},
+		{"no leader", kafka.TopicMetadata{Error: kafka.NewError(kafka.ErrLeaderNotAvailable, "", false)}, true, true},
+		{"denied", kafka.TopicMetadata{Error: kafka.NewError(kafka.ErrTopicAuthorizationFailed, "", false)}, true, false},
+	}
+	for _, c := range cases {
+		if pending := topicPending(c.metadata, c.exists); pending != c.pending {
+			t.Errorf("%s: expected pending %v, got %v", c.name, c.pending, pending)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package kafka_client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// decodeMsgpack decodes a MessagePack map into a flattened record, typed like
+// the JSON records: numbers as float64, along with strings, booleans and nil.
+// Binary values are base64 encoded and timestamps formatted as RFC 3339.
+func decodeMsgpack(value []byte) (map[string]interface{}, error) {
+	reader := bytes.NewReader(value)
+	decoder := msgpack.NewDecoder(reader)
+	decoder.UseDecodeInterfaceLoose(true)
+	decoder.SetDecodeMapFunc(decodeMsgpackMap)
+
+	decoded, err := decoder.DecodeInterfaceLoose()
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after the msgpack value", reader.Len())
+	}
+	record, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a msgpack map, got %T", decoded)
+	}
+
+	out := make(map[string]interface{}, len(record))
+	flatten("", jsonTyped(record).(map[string]interface{}), out)
+
+	return out, nil
+}
+
+// decodeMsgpackMap decodes the maps with string keys, formatting the other
+// keys, so that they flatten like the JSON objects.
+func decodeMsgpackMap(decoder *msgpack.Decoder) (interface{}, error) {
+	n, err := decoder.DecodeMapLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+	// Sized as it fills, the length of a malformed map being arbitrary.
+	m := make(map[string]interface{})
+	for i := 0; i < n; i++ {
+		key, err := decoder.DecodeInterfaceLoose()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decoder.DecodeInterfaceLoose()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, nil
+}
+
+// jsonTyped converts the decoded values to the types of the JSON records.
+func jsonTyped(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case *time.Time:
+		// The timestamp extension decodes to a pointer.
+		return v.UTC().Format(time.RFC3339Nano)
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonTyped(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for key, field := range v {
+			v[key] = jsonTyped(field)
+		}
+		return v
+	default:
+		return v
+	}
+}
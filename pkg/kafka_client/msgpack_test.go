@@ -0,0 +1,55 @@
+package kafka_client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMsgpack(t *testing.T) {
+	value := []byte{
+		0x86,                                                    // map of 6
+		0xa3, 'c', 'p', 'u', 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0, // 0.5
+		0xa4, 'h', 'o', 's', 't', 0xa1, 'a',
+		0xa2, 'u', 'p', 0xc3,
+		0xa1, 'n', 0xfd, // -3
+		0xa5, 'c', 'o', 'u', 'n', 't', 0xcd, 0x01, 0x2c, // 300
+		0xa4, 't', 'a', 'g', 's', 0x81, 0xa2, 'd', 'c', 0xa2, 'e', 'u',
+	}
+	expected := map[string]interface{}{"cpu": 0.5, "host": "a", "up": true, "n": -3.0, "count": 300.0, "tags.dc": "eu"}
+
+	records, err := decodeValue(value, DecodeOptions{Format: FORMAT_MSGPACK})
+	if err != nil || !reflect.DeepEqual(records, []map[string]interface{}{expected}) {
+		t.Errorf("expected %v, got %v (%v)", expected, records, err)
+	}
+
+	if _, err := decodeMsgpack(value[:len(value)-1]); err == nil {
+		t.Errorf("expected a truncated value to fail")
+	}
+	if _, err := decodeMsgpack([]byte{0x93, 1, 2, 3}); err == nil {
+		t.Errorf("expected an array to fail")
+	}
+	if _, err := decodeMsgpack(append(value, 0xc0)); err == nil {
+		t.Errorf("expected trailing bytes to fail")
+	}
+}
+
+func TestDecodeMsgpackTypes(t *testing.T) {
+	value := []byte{
+		0x84,                               // map of 4
+		0xa3, 'b', 'i', 'n', 0xc4, 2, 1, 2, // binary
+		0xa2, 't', 's', 0xd6, 0xff, 0, 0, 0, 60, // timestamp 32
+		0xa3, 'i', 'd', 's', 0x92, 1, 0xd0, 0xfe, // [1, -2]
+		0xa4, 'b', 'y', 'i', 'd', 0x81, 0x07, 0xa1, 'x', // {7: "x"}
+	}
+	expected := map[string]interface{}{
+		"bin":    "AQI=",
+		"ts":     "1970-01-01T00:01:00Z",
+		"ids":    []interface{}{1.0, -2.0},
+		"byid.7": "x",
+	}
+
+	record, err := decodeMsgpack(value)
+	if err != nil || !reflect.DeepEqual(record, expected) {
+		t.Errorf("expected %v, got %v (%v)", expected, record, err)
+	}
+}
@@ -0,0 +1,186 @@
+package kafka_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Number of messages pulled by the partition workers ahead of the stream.
+const PARALLEL_BUFFER = 1000
+
+// partitionWorkers read the partitions of a topic with a consumer each, in a
+// goroutine of their own, so that a busy partition doesn't hold back the
+// others. Their messages are merged into a channel pulled by the client.
+type partitionWorkers struct {
+	clients []*KafkaClient
+	pulled  chan pulledMessage
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// pulledMessage is a message or error pulled by a worker, or the end of the
+// history of its partition.
+type pulledMessage struct {
+	message    *ConsumedMessage
+	err        error
+	endReached *partitionKey
+}
+
+// assignParallel assigns every partition of the topic to a worker.
+func (client *KafkaClient) assignParallel(ctx context.Context, topic string) error {
+	// The consumer of the client only reads the metadata, the ones of the
+	// workers stream.
+	if err := client.consumerInitialize(); err != nil {
+		return err
+	}
+	defer func() {
+		client.Consumer.Close()
+		client.Consumer = nil
+	}()
+	topicMetadata, exists, err := client.topicMetadata(ctx, topic)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no metadata found for topic %s", topic)
+	}
+	if topicMetadata.Error.Code() != 0 {
+		return topicMetadata.Error
+	}
+	if len(topicMetadata.Partitions) == 0 {
+		return errors.New("topic " + topic + " has no partitions")
+	}
+
+	workers := &partitionWorkers{
+		pulled: make(chan pulledMessage, PARALLEL_BUFFER),
+		stop:   make(chan struct{}),
+	}
+	for _, partition := range topicMetadata.Partitions {
+		worker := *client
+		worker.Consumer = nil
+		worker.ParallelPartitions = false
+		worker.workers = nil
+		worker.endReached = nil
+		worker.endOffsets = nil
+		// The workers record the offsets of their own partition, the client
+		// the ones of the messages it pulls from them.
+		worker.resumeOffsets = nil
+		key := partitionKey{topic, partition.ID}
+		if offset, exists := client.resumeOffsets[key]; exists {
+			worker.resumeOffsets = map[partitionKey]int64{key: offset}
+		}
+		worker.statsConsumer = ""
+		if err := worker.TopicAssign(ctx, topic, partition.ID, client.AutoOffsetReset, client.TimestampMode, client.PrefetchLast); err != nil {
+			// The consumer of the failed worker may already be created.
+			worker.Dispose()
+			workers.close()
+			return fmt.Errorf("error assigning partition %d: %w", partition.ID, err)
+		}
+		workers.clients = append(workers.clients, &worker)
+	}
+	workers.start(client)
+
+	return nil
+}
+
+// start hands the assigned workers over to the client, then starts them.
+func (workers *partitionWorkers) start(client *KafkaClient) {
+	// The client tells the end of the partitions of the workers, from the
+	// messages it pulls.
+	client.endOffsets = nil
+	for _, worker := range workers.clients {
+		for key, offsets := range worker.endOffsets {
+			if client.endOffsets == nil {
+				client.endOffsets = make(map[partitionKey]offsetRange)
+			}
+			client.endOffsets[key] = offsets
+		}
+	}
+	// The start offset is only told when the partitions share it, like the
+	// latest offset.
+	shared := true
+	for _, worker := range workers.clients[1:] {
+		if worker.StartOffset != workers.clients[0].StartOffset {
+			shared = false
+			break
+		}
+	}
+	if shared {
+		client.StartOffset = workers.clients[0].StartOffset
+	}
+
+	client.workers = workers
+	for _, worker := range workers.clients {
+		workers.wg.Add(1)
+		go workers.run(worker)
+	}
+}
+
+// run pulls the messages of the worker until the workers stop.
+func (workers *partitionWorkers) run(worker *KafkaClient) {
+	defer workers.wg.Done()
+
+	historyRead := false
+	for {
+		select {
+		case <-workers.stop:
+			return
+		default:
+		}
+
+		msg, err := worker.ConsumerPull()
+		pulled := pulledMessage{message: msg, err: err}
+		if worker.FromBeginning && !historyRead && worker.HistoryRead() {
+			historyRead = true
+			// The only partition of the worker.
+			for key := range worker.endReached {
+				key := key
+				pulled.endReached = &key
+			}
+		}
+		if msg == nil && err == nil && pulled.endReached == nil {
+			continue
+		}
+
+		select {
+		case workers.pulled <- pulled:
+		case <-workers.stop:
+			return
+		}
+	}
+}
+
+// pull returns the next message of the workers, recording the end of the
+// history of their partitions. It returns a nil message when none came in
+// time, like ConsumerPull.
+func (workers *partitionWorkers) pull(client *KafkaClient) (*ConsumedMessage, error) {
+	select {
+	case pulled := <-workers.pulled:
+		if pulled.endReached != nil {
+			if client.endReached == nil {
+				client.endReached = make(map[partitionKey]bool)
+			}
+			client.endReached[*pulled.endReached] = true
+		}
+		return pulled.message, pulled.err
+	case <-time.After(100 * time.Millisecond):
+		return nil, nil
+	}
+}
+
+// historyRead tells whether every worker reached the end of its partition.
+func (workers *partitionWorkers) historyRead(client *KafkaClient) bool {
+	return len(client.endReached) == len(workers.clients)
+}
+
+// close stops the workers, then closes their consumers.
+func (workers *partitionWorkers) close() {
+	close(workers.stop)
+	workers.wg.Wait()
+	for _, worker := range workers.clients {
+		worker.Dispose()
+	}
+}
@@ -0,0 +1,114 @@
+package kafka_client
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func newTestWorkers(clients ...*KafkaClient) *partitionWorkers {
+	return &partitionWorkers{
+		clients: clients,
+		pulled:  make(chan pulledMessage, PARALLEL_BUFFER),
+		stop:    make(chan struct{}),
+	}
+}
+
+func TestPartitionWorkersStart(t *testing.T) {
+	first := &KafkaClient{StartOffset: 5, endOffsets: map[partitionKey]offsetRange{{"test", 0}: {5, 10}}}
+	second := &KafkaClient{StartOffset: 5, endOffsets: map[partitionKey]offsetRange{{"test", 1}: {5, 8}}}
+	client := &KafkaClient{
+		StartOffset: int64(kafka.OffsetInvalid),
+		endOffsets:  map[partitionKey]offsetRange{{"other", 0}: {0, 1}},
+	}
+
+	newTestWorkers(first, second).start(client)
+	if client.workers == nil {
+		t.Fatal("expected the client to pull from the workers")
+	}
+	if len(client.endOffsets) != 2 || client.endOffsets[partitionKey{"test", 1}] != (offsetRange{5, 8}) {
+		t.Errorf("expected the end offsets of the workers, got %v", client.endOffsets)
+	}
+	if client.StartOffset != 5 {
+		t.Errorf("expected the shared start offset, got %d", client.StartOffset)
+	}
+	// The workers without a consumer pull its error.
+	if _, err := client.ConsumerPull(); err != ErrNoConsumer {
+		t.Errorf("expected the error of a worker, got %v", err)
+	}
+
+	client.Dispose()
+	if client.workers != nil {
+		t.Error("expected the workers to be stopped")
+	}
+
+	client = &KafkaClient{StartOffset: int64(kafka.OffsetInvalid)}
+	newTestWorkers(&KafkaClient{StartOffset: 5}, &KafkaClient{StartOffset: 7}).start(client)
+	client.Dispose()
+	if client.StartOffset != int64(kafka.OffsetInvalid) {
+		t.Errorf("expected no start offset for distinct offsets, got %d", client.StartOffset)
+	}
+	if len(client.endOffsets) != 0 {
+		t.Errorf("expected no end offsets, got %v", client.endOffsets)
+	}
+}
+
+func TestPartitionWorkersHistoryRead(t *testing.T) {
+	client := &KafkaClient{}
+	workers := newTestWorkers(&KafkaClient{}, &KafkaClient{})
+	client.workers = workers
+
+	workers.pulled <- pulledMessage{endReached: &partitionKey{"test", 0}}
+	workers.pulled <- pulledMessage{endReached: &partitionKey{"test", 0}}
+	client.ConsumerPull()
+	client.ConsumerPull()
+	if client.HistoryRead() {
+		t.Error("expected the history to be read once every partition ends")
+	}
+	workers.pulled <- pulledMessage{endReached: &partitionKey{"test", 1}}
+	client.ConsumerPull()
+	if !client.HistoryRead() {
+		t.Error("expected the history to be read")
+	}
+
+	client.Dispose()
+}
+
+func TestKafkaClientEnded(t *testing.T) {
+	latest := int64(kafka.OffsetEnd)
+	tests := map[string]struct {
+		endOffsets    map[partitionKey]offsetRange
+		resumeOffsets map[partitionKey]int64
+		ended         bool
+	}{
+		"no end time": {},
+		"nothing consumed": {
+			endOffsets: map[partitionKey]offsetRange{{"test", 0}: {0, 10}},
+		},
+		"partly consumed": {
+			endOffsets:    map[partitionKey]offsetRange{{"test", 0}: {0, 10}, {"test", 1}: {0, 10}},
+			resumeOffsets: map[partitionKey]int64{{"test", 0}: 10, {"test", 1}: 9},
+		},
+		"consumed": {
+			endOffsets:    map[partitionKey]offsetRange{{"test", 0}: {0, 10}, {"test", 1}: {0, 10}},
+			resumeOffsets: map[partitionKey]int64{{"test", 0}: 10, {"test", 1}: 11},
+			ended:         true,
+		},
+		"empty range": {
+			endOffsets: map[partitionKey]offsetRange{{"test", 0}: {10, 10}},
+			ended:      true,
+		},
+		"latest offset": {
+			endOffsets:    map[partitionKey]offsetRange{{"test", 0}: {latest, 10}, {"test", 1}: {0, 5}},
+			resumeOffsets: map[partitionKey]int64{{"test", 1}: 5},
+			ended:         true,
+		},
+	}
+
+	for name, test := range tests {
+		client := &KafkaClient{endOffsets: test.endOffsets, resumeOffsets: test.resumeOffsets}
+		if ended := client.Ended(); ended != test.ended {
+			t.Errorf("%s: expected %v, got %v", name, test.ended, ended)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package kafka_client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pooledConsumers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "grafana_kafka_datasource",
+	Name:      "pooled_consumers",
+	Help:      "Number of consumers shared by the metadata operations.",
+})
+
+// ConsumerPool shares a consumer between the metadata operations of the
+// clients with the same settings, like health checks and topic listings,
+// so that they don't each open connections to the brokers. The consumers
+// reading messages are never pooled. A nil pool shares nothing.
+type ConsumerPool struct {
+	mu        sync.Mutex
+	consumers map[string]*pooledConsumer
+	closed    bool
+}
+
+// pooledConsumer counts the operations using a consumer, which the pool
+// only closes once the last of them released it.
+type pooledConsumer struct {
+	consumer *kafka.Consumer
+	leases   int
+}
+
+func NewConsumerPool() *ConsumerPool {
+	return &ConsumerPool{consumers: make(map[string]*pooledConsumer)}
+}
+
+// get leases the consumer of the config, creating it on the first use. The
+// returned function releases the lease.
+func (pool *ConsumerPool) get(config kafka.ConfigMap) (*kafka.Consumer, func(), error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.closed {
+		return nil, nil, ErrNoConsumer
+	}
+	key := configKey(config)
+	pooled, exists := pool.consumers[key]
+	if !exists {
+		consumer, err := kafka.NewConsumer(&config)
+		if err != nil {
+			return nil, nil, err
+		}
+		pooled = &pooledConsumer{consumer: consumer}
+		pool.consumers[key] = pooled
+		pooledConsumers.Inc()
+	}
+	pooled.leases++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { pool.release(key, pooled) })
+	}
+
+	return pooled.consumer, release, nil
+}
+
+// release ends a lease, closing the consumer when the pool was closed while
+// it was in use.
+func (pool *ConsumerPool) release(key string, pooled *pooledConsumer) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pooled.leases--
+	if pool.closed && pooled.leases == 0 {
+		pool.remove(key, pooled)
+	}
+}
+
+func (pool *ConsumerPool) remove(key string, pooled *pooledConsumer) {
+	pooled.consumer.Close()
+	delete(pool.consumers, key)
+	pooledConsumers.Dec()
+}
+
+// PoolCollectors returns the metrics of the pooled consumers, which the
+// plugin registers once.
+func PoolCollectors() []prometheus.Collector {
+	return []prometheus.Collector{pooledConsumers}
+}
+
+// Size returns the number of pooled consumers.
+func (pool *ConsumerPool) Size() int {
+	if pool == nil {
+		return 0
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	return len(pool.consumers)
+}
+
+// Close closes the pooled consumers which aren't in use, and the others as
+// they are released. The pool creates none afterwards.
+func (pool *ConsumerPool) Close() {
+	if pool == nil {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for key, pooled := range pool.consumers {
+		if pooled.leases == 0 {
+			pool.remove(key, pooled)
+		}
+	}
+	pool.closed = true
+}
+
+// configKey identifies a config by its sorted settings.
+func configKey(config kafka.ConfigMap) string {
+	settings := make([]string, 0, len(config))
+	for key, value := range config {
+		settings = append(settings, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(settings)
+
+	return strings.Join(settings, "\n")
+}
+
+// sharedConsumerInitialize sets the consumer of the operations which don't
+// consume messages, taken from the pool when the client has one. The
+// returned function releases it.
+func (client *KafkaClient) sharedConsumerInitialize() (func(), error) {
+	if client.Pool == nil {
+		if err := client.consumerInitialize(); err != nil {
+			return nil, err
+		}
+		return client.Dispose, nil
+	}
+
+	consumer, release, err := client.Pool.get(client.consumerConfig())
+	if err != nil {
+		return nil, err
+	}
+	client.Consumer = consumer
+
+	return func() {
+		client.Consumer = nil
+		release()
+	}, nil
+}
@@ -0,0 +1,45 @@
+package kafka_client
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestConsumerPool(t *testing.T) {
+	pool := NewConsumerPool()
+
+	first, releaseFirst, err := pool.get(kafka.ConfigMap{"bootstrap.servers": "a:9092", "group.id": DEFAULT_GROUP_ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, releaseSame, _ := pool.get(kafka.ConfigMap{"group.id": DEFAULT_GROUP_ID, "bootstrap.servers": "a:9092"})
+	if same != first {
+		t.Error("expected identical settings to share a consumer")
+	}
+	_, releaseOther, err := pool.get(kafka.ConfigMap{"bootstrap.servers": "b:9092", "group.id": DEFAULT_GROUP_ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pool.Size() != 2 {
+		t.Errorf("expected a consumer per settings, got %d", pool.Size())
+	}
+	releaseOther()
+
+	pool.Close()
+	if pool.Size() != 1 {
+		t.Errorf("expected the consumer in use to stay open, got %d", pool.Size())
+	}
+	releaseFirst()
+	releaseFirst()
+	if pool.Size() != 1 {
+		t.Errorf("expected the consumer to stay open until its last release, got %d", pool.Size())
+	}
+	releaseSame()
+	if pool.Size() != 0 {
+		t.Errorf("expected the consumers to be closed, got %d", pool.Size())
+	}
+	if _, _, err := pool.get(kafka.ConfigMap{"bootstrap.servers": "a:9092"}); err == nil {
+		t.Error("expected a closed pool to create no consumer")
+	}
+}
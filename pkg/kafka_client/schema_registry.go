@@ -0,0 +1,272 @@
+package kafka_client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Timeout of the requests to the schema registry.
+const SCHEMA_REGISTRY_TIMEOUT = 10 * time.Second
+
+// Time the failed schema lookups are cached, so that the messages of a
+// missing schema don't each query the registry.
+const SCHEMA_REGISTRY_ERROR_TTL = 30 * time.Second
+
+var ErrNoSchemaRegistry = errors.New("no schema registry is configured")
+
+// SchemaRegistry fetches the schemas of a Confluent Schema Registry, caching
+// the compiled protobuf descriptors by schema id. A nil registry decodes
+// nothing.
+type SchemaRegistry struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	mu      sync.Mutex
+	schemas map[int32]*schemaEntry
+}
+
+// schemaEntry is a schema fetched once for all the messages which need it,
+// the fetch running without the lock of the registry.
+type schemaEntry struct {
+	// Closed once the schema is fetched, along with its error.
+	done    chan struct{}
+	file    protoreflect.FileDescriptor
+	err     error
+	expires time.Time
+}
+
+// expired tells whether the schema failed to be fetched long enough ago to
+// be fetched again.
+func (entry *schemaEntry) expired(now time.Time) bool {
+	select {
+	case <-entry.done:
+		return entry.err != nil && now.After(entry.expires)
+	default:
+		return false
+	}
+}
+
+type registrySchema struct {
+	Schema     string              `json:"schema"`
+	SchemaType string              `json:"schemaType"`
+	References []registryReference `json:"references"`
+}
+
+type registryReference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+func NewSchemaRegistry(url string, username string, password string) *SchemaRegistry {
+	return &SchemaRegistry{
+		url:      strings.TrimRight(url, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: SCHEMA_REGISTRY_TIMEOUT},
+		schemas:  make(map[int32]*schemaEntry),
+	}
+}
+
+// DecodeProtobuf decodes a message serialized by the Confluent protobuf
+// serializer into a flattened record.
+func (registry *SchemaRegistry) DecodeProtobuf(value []byte) (map[string]interface{}, error) {
+	if registry == nil {
+		return nil, ErrNoSchemaRegistry
+	}
+
+	id, indexes, payload, err := parseProtobufWireFormat(value)
+	if err != nil {
+		return nil, err
+	}
+	file, err := registry.protobufSchema(id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching schema %d: %w", id, err)
+	}
+	descriptor, err := messageAt(file, indexes)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+
+	message := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, err
+	}
+	decoded, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJSON(decoded)
+}
+
+// parseProtobufWireFormat splits a value of the Confluent wire format: a zero
+// magic byte, the 4-byte big-endian schema id, and for protobuf the path of
+// the message in the schema as a zigzag varint count followed by as many
+// zigzag varint indexes, a single 0 standing for the first message.
+func parseProtobufWireFormat(value []byte) (int32, []int, []byte, error) {
+	if len(value) < 6 || value[0] != 0 {
+		return 0, nil, nil, errors.New("not a schema registry message, the magic byte is missing")
+	}
+	id := int32(binary.BigEndian.Uint32(value[1:5]))
+	rest := value[5:]
+
+	count, n := binary.Varint(rest)
+	if n <= 0 || count < 0 || count > int64(len(rest)) {
+		return 0, nil, nil, errors.New("invalid message indexes")
+	}
+	rest = rest[n:]
+	if count == 0 {
+		return id, []int{0}, rest, nil
+	}
+
+	indexes := make([]int, count)
+	for i := range indexes {
+		index, n := binary.Varint(rest)
+		if n <= 0 || index < 0 {
+			return 0, nil, nil, errors.New("invalid message indexes")
+		}
+		indexes[i] = int(index)
+		rest = rest[n:]
+	}
+
+	return id, indexes, rest, nil
+}
+
+// messageAt follows the message indexes, the first one into the top level
+// messages of the file and the next ones into the nested messages.
+func messageAt(file protoreflect.FileDescriptor, indexes []int) (protoreflect.MessageDescriptor, error) {
+	messages := file.Messages()
+	var message protoreflect.MessageDescriptor
+	for _, index := range indexes {
+		if index >= messages.Len() {
+			return nil, fmt.Errorf("no message at index %v", indexes)
+		}
+		message = messages.Get(index)
+		messages = message.Messages()
+	}
+	if message == nil {
+		return nil, errors.New("the schema has no message")
+	}
+
+	return message, nil
+}
+
+// protobufSchema returns the compiled schema, fetching it along with its
+// references on the first use. The concurrent lookups of a schema wait for
+// the same fetch, and its failure is kept for SCHEMA_REGISTRY_ERROR_TTL.
+func (registry *SchemaRegistry) protobufSchema(id int32) (protoreflect.FileDescriptor, error) {
+	registry.mu.Lock()
+	entry, exists := registry.schemas[id]
+	if exists && !entry.expired(time.Now()) {
+		registry.mu.Unlock()
+		<-entry.done
+		return entry.file, entry.err
+	}
+	entry = &schemaEntry{done: make(chan struct{})}
+	registry.schemas[id] = entry
+	registry.mu.Unlock()
+
+	entry.file, entry.err = registry.fetchProtobufSchema(id)
+	if entry.err != nil {
+		entry.expires = time.Now().Add(SCHEMA_REGISTRY_ERROR_TTL)
+	}
+	close(entry.done)
+
+	return entry.file, entry.err
+}
+
+// fetchProtobufSchema fetches the schema and its references, and compiles
+// them into the descriptors of the protobuf runtime.
+func (registry *SchemaRegistry) fetchProtobufSchema(id int32) (protoreflect.FileDescriptor, error) {
+	var schema registrySchema
+	if err := registry.get(fmt.Sprintf("/schemas/ids/%d", id), &schema); err != nil {
+		return nil, err
+	}
+	if schema.SchemaType != "PROTOBUF" {
+		return nil, fmt.Errorf("expected a protobuf schema, got %q", schema.SchemaType)
+	}
+
+	name := fmt.Sprintf("%d.proto", id)
+	files := map[string]string{name: schema.Schema}
+	if err := registry.fetchReferences(schema.References, files); err != nil {
+		return nil, err
+	}
+
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(files)}
+	parsed, err := parser.ParseFiles(name)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := protodesc.NewFiles(desc.ToFileDescriptorSet(parsed[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	return compiled.FindFileByPath(name)
+}
+
+// fetchReferences adds the imported schemas to files, by import name.
+func (registry *SchemaRegistry) fetchReferences(references []registryReference, files map[string]string) error {
+	for _, reference := range references {
+		if _, exists := files[reference.Name]; exists {
+			continue
+		}
+		var schema registrySchema
+		path := fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(reference.Subject), reference.Version)
+		if err := registry.get(path, &schema); err != nil {
+			return fmt.Errorf("error fetching reference %s: %w", reference.Name, err)
+		}
+		files[reference.Name] = schema.Schema
+		if err := registry.fetchReferences(schema.References, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (registry *SchemaRegistry) get(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, registry.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if registry.username != "" {
+		req.SetBasicAuth(registry.username, registry.password)
+	}
+
+	resp, err := registry.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("schema registry returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,103 @@
+package kafka_client
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseProtobufWireFormat(t *testing.T) {
+	id, indexes, payload, err := parseProtobufWireFormat([]byte{0, 0, 0, 1, 7, 0, 0x08, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 263 || !reflect.DeepEqual(indexes, []int{0}) || !bytes.Equal(payload, []byte{0x08, 0x01}) {
+		t.Errorf("unexpected schema %d, indexes %v and payload %v", id, indexes, payload)
+	}
+
+	_, indexes, payload, err = parseProtobufWireFormat([]byte{0, 0, 0, 0, 7, 4, 2, 4, 0x08, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexes, []int{1, 2}) || !bytes.Equal(payload, []byte{0x08, 0x01}) {
+		t.Errorf("unexpected indexes %v and payload %v", indexes, payload)
+	}
+
+	if _, _, _, err := parseProtobufWireFormat([]byte(`{"a":1}`)); err == nil {
+		t.Error("expected an error without the magic byte")
+	}
+}
+
+func TestSchemaRegistryCachesFailures(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(10 * time.Millisecond)
+		http.Error(w, `{"error_code":40403,"message":"Schema not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	registry := NewSchemaRegistry(server.URL, "", "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := registry.protobufSchema(1); err == nil {
+				t.Error("expected the missing schema to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := registry.protobufSchema(1); err == nil {
+		t.Error("expected the missing schema to keep failing")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected a single request to the registry, got %d", n)
+	}
+
+	registry.schemas[1].expires = time.Now().Add(-time.Second)
+	registry.protobufSchema(1)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected the failure to expire, got %d requests", n)
+	}
+}
+
+func TestSchemaRegistryDecodeProtobuf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			w.Write([]byte(`{"schemaType":"PROTOBUF","schema":"syntax = \"proto3\"; import \"host.proto\"; message Reading { Host host = 1; message Latency { int64 ms = 1; } Latency latency = 2; }","references":[{"name":"host.proto","subject":"host","version":1}]}`))
+		case "/subjects/host/versions/1":
+			w.Write([]byte(`{"schemaType":"PROTOBUF","schema":"syntax = \"proto3\"; message Host { string name = 1; }"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	registry := NewSchemaRegistry(server.URL, "", "")
+
+	// Reading{host: {name: "a"}, latency: {ms: 5}}
+	payload := []byte{0x0a, 0x03, 0x0a, 0x01, 'a', 0x12, 0x02, 0x08, 0x05}
+	decoded, err := registry.DecodeProtobuf(append([]byte{0, 0, 0, 0, 1, 0}, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded["host.name"] != "a" {
+		t.Errorf("unexpected record %v", decoded)
+	}
+
+	// Reading.Latency{ms: 5}
+	decoded, err = registry.DecodeProtobuf([]byte{0, 0, 0, 0, 1, 4, 0, 0, 0x08, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := decoded["ms"]; !exists {
+		t.Errorf("unexpected nested record %v", decoded)
+	}
+}
@@ -0,0 +1,217 @@
+package kafka_client
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Maximum interval of the librdkafka statistics, one day.
+const MAX_STATISTICS_INTERVAL_MS int32 = 86400000
+
+var (
+	brokerRtt = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "broker_rtt_seconds",
+		Help:      "99th percentile of the round trip time of the requests of the stream consumers to the brokers, mostly fetches.",
+	}, []string{"consumer", "broker", "quantile"})
+	brokerRttAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "broker_rtt_avg_seconds",
+		Help:      "Average round trip time of the requests of the stream consumers to the brokers, mostly fetches.",
+	}, []string{"consumer", "broker"})
+	fetchLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "fetch_latency_avg_seconds",
+		Help:      "Average time of the requests of the stream consumers from their queueing to their response, mostly fetches.",
+	}, []string{"consumer", "broker"})
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "consumer_lag",
+		Help:      "Number of messages of the partitions the stream consumers have yet to read.",
+	}, []string{"consumer", "topic", "partition"})
+)
+
+// StatsCollectors returns the metrics of the statistics of the stream
+// consumers, which the plugin registers once.
+func StatsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{brokerRtt, brokerRttAvg, fetchLatency, consumerLag}
+}
+
+// ConsumerStats are the figures of a stream consumer picked from its last
+// librdkafka statistics.
+type ConsumerStats struct {
+	Consumer   string           `json:"consumer"`
+	Time       time.Time        `json:"time"`
+	Brokers    []BrokerStats    `json:"brokers"`
+	Partitions []PartitionStats `json:"partitions"`
+}
+
+type BrokerStats struct {
+	Name     string  `json:"name"`
+	State    string  `json:"state"`
+	RttAvgMs float64 `json:"rttAvgMs"`
+	RttP99Ms float64 `json:"rttP99Ms"`
+	// Time of the requests waiting to be sent, mostly fetches, added to their
+	// round trip time.
+	FetchLatencyAvgMs float64 `json:"fetchLatencyAvgMs"`
+}
+
+type PartitionStats struct {
+	Topic      string `json:"topic"`
+	Partition  int32  `json:"partition"`
+	FetchState string `json:"fetchState"`
+	// -1 until the high watermark and the position are known.
+	ConsumerLag int64 `json:"consumerLag"`
+}
+
+// rawStats is the part of the librdkafka statistics JSON read, with the
+// times in microseconds.
+type rawStats struct {
+	Name    string `json:"name"`
+	Brokers map[string]struct {
+		Name   string `json:"name"`
+		NodeId int32  `json:"nodeid"`
+		State  string `json:"state"`
+		Rtt    struct {
+			Avg int64 `json:"avg"`
+			P99 int64 `json:"p99"`
+		} `json:"rtt"`
+		OutbufLatency struct {
+			Avg int64 `json:"avg"`
+		} `json:"outbuf_latency"`
+	} `json:"brokers"`
+	Topics map[string]struct {
+		Partitions map[string]struct {
+			Partition   int32  `json:"partition"`
+			FetchState  string `json:"fetch_state"`
+			ConsumerLag int64  `json:"consumer_lag"`
+		} `json:"partitions"`
+	} `json:"topics"`
+}
+
+// parseStats picks the figures of the statistics of a consumer, leaving out
+// the bootstrap brokers and the internal partition of the unassigned
+// messages, sorted so that they read alike from an event to the next.
+func parseStats(statistics string) (ConsumerStats, error) {
+	var raw rawStats
+	if err := json.Unmarshal([]byte(statistics), &raw); err != nil {
+		return ConsumerStats{}, err
+	}
+
+	stats := ConsumerStats{Consumer: raw.Name, Time: time.Now().UTC()}
+	for _, broker := range raw.Brokers {
+		if broker.NodeId < 0 {
+			continue
+		}
+		stats.Brokers = append(stats.Brokers, BrokerStats{
+			Name:              broker.Name,
+			State:             broker.State,
+			RttAvgMs:          float64(broker.Rtt.Avg) / 1000,
+			RttP99Ms:          float64(broker.Rtt.P99) / 1000,
+			FetchLatencyAvgMs: float64(broker.OutbufLatency.Avg+broker.Rtt.Avg) / 1000,
+		})
+	}
+	for topic, topicStats := range raw.Topics {
+		for _, partition := range topicStats.Partitions {
+			if partition.Partition < 0 {
+				continue
+			}
+			stats.Partitions = append(stats.Partitions, PartitionStats{
+				Topic:       topic,
+				Partition:   partition.Partition,
+				FetchState:  partition.FetchState,
+				ConsumerLag: partition.ConsumerLag,
+			})
+		}
+	}
+	sort.Slice(stats.Brokers, func(i, j int) bool {
+		return stats.Brokers[i].Name < stats.Brokers[j].Name
+	})
+	sort.Slice(stats.Partitions, func(i, j int) bool {
+		a, b := stats.Partitions[i], stats.Partitions[j]
+		return a.Topic < b.Topic || (a.Topic == b.Topic && a.Partition < b.Partition)
+	})
+
+	return stats, nil
+}
+
+// StatsRecorder keeps the last statistics of the stream consumers of a
+// datasource and exports them as metrics. A nil recorder records nothing.
+type StatsRecorder struct {
+	mu    sync.Mutex
+	stats map[string]ConsumerStats
+}
+
+func NewStatsRecorder() *StatsRecorder {
+	return &StatsRecorder{stats: make(map[string]ConsumerStats)}
+}
+
+// record replaces the statistics of the consumer.
+func (recorder *StatsRecorder) record(stats ConsumerStats) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	deleteStatsMetrics(recorder.stats[stats.Consumer])
+	recorder.stats[stats.Consumer] = stats
+	for _, broker := range stats.Brokers {
+		brokerRtt.WithLabelValues(stats.Consumer, broker.Name, "0.99").Set(broker.RttP99Ms / 1000)
+		brokerRttAvg.WithLabelValues(stats.Consumer, broker.Name).Set(broker.RttAvgMs / 1000)
+		fetchLatency.WithLabelValues(stats.Consumer, broker.Name).Set(broker.FetchLatencyAvgMs / 1000)
+	}
+	for _, partition := range stats.Partitions {
+		if partition.ConsumerLag >= 0 {
+			consumerLag.WithLabelValues(stats.Consumer, partition.Topic, strconv.Itoa(int(partition.Partition))).
+				Set(float64(partition.ConsumerLag))
+		}
+	}
+}
+
+// remove drops the statistics of a closed consumer.
+func (recorder *StatsRecorder) remove(consumer string) {
+	if recorder == nil || consumer == "" {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	deleteStatsMetrics(recorder.stats[consumer])
+	delete(recorder.stats, consumer)
+}
+
+func deleteStatsMetrics(stats ConsumerStats) {
+	for _, broker := range stats.Brokers {
+		brokerRtt.DeleteLabelValues(stats.Consumer, broker.Name, "0.99")
+		brokerRttAvg.DeleteLabelValues(stats.Consumer, broker.Name)
+		fetchLatency.DeleteLabelValues(stats.Consumer, broker.Name)
+	}
+	for _, partition := range stats.Partitions {
+		consumerLag.DeleteLabelValues(stats.Consumer, partition.Topic, strconv.Itoa(int(partition.Partition)))
+	}
+}
+
+// Stats returns the last statistics of the consumers, sorted by consumer.
+func (recorder *StatsRecorder) Stats() []ConsumerStats {
+	if recorder == nil {
+		return nil
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	stats := make([]ConsumerStats, 0, len(recorder.stats))
+	for _, consumer := range recorder.stats {
+		stats = append(stats, consumer)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Consumer < stats[j].Consumer
+	})
+
+	return stats
+}
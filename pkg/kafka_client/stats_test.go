@@ -0,0 +1,64 @@
+package kafka_client
+
+import (
+	"testing"
+)
+
+const testStatistics = `{
+	"name": "rdkafka#consumer-1",
+	"brokers": {
+		"broker:9092/bootstrap": {"name": "broker:9092/bootstrap", "nodeid": -1, "state": "UP", "rtt": {"avg": 0, "p99": 0}},
+		"broker2:9092/2": {"name": "broker2:9092/2", "nodeid": 2, "state": "UP", "rtt": {"avg": 3000, "p99": 12500}, "outbuf_latency": {"avg": 500}},
+		"broker1:9092/1": {"name": "broker1:9092/1", "nodeid": 1, "state": "DOWN", "rtt": {"avg": 1500, "p99": 4000}}
+	},
+	"topics": {
+		"orders": {"topic": "orders", "partitions": {
+			"1": {"partition": 1, "fetch_state": "active", "consumer_lag": 7},
+			"0": {"partition": 0, "fetch_state": "active", "consumer_lag": 0},
+			"-1": {"partition": -1, "fetch_state": "none", "consumer_lag": -1}
+		}}
+	}
+}`
+
+func TestParseStats(t *testing.T) {
+	stats, err := parseStats(testStatistics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Consumer != "rdkafka#consumer-1" {
+		t.Errorf("unexpected consumer %q", stats.Consumer)
+	}
+	if len(stats.Brokers) != 2 || stats.Brokers[0].Name != "broker1:9092/1" || stats.Brokers[0].State != "DOWN" ||
+		stats.Brokers[1].RttAvgMs != 3 || stats.Brokers[1].RttP99Ms != 12.5 || stats.Brokers[1].FetchLatencyAvgMs != 3.5 {
+		t.Errorf("expected the sorted brokers without the bootstrap one, got %+v", stats.Brokers)
+	}
+	if len(stats.Partitions) != 2 || stats.Partitions[0].Partition != 0 || stats.Partitions[1].ConsumerLag != 7 {
+		t.Errorf("expected the sorted partitions without the internal one, got %+v", stats.Partitions)
+	}
+
+	if _, err := parseStats("{"); err == nil {
+		t.Errorf("expected invalid statistics to fail")
+	}
+}
+
+func TestStatsRecorder(t *testing.T) {
+	recorder := NewStatsRecorder()
+	stats, _ := parseStats(testStatistics)
+
+	recorder.record(stats)
+	if recorded := recorder.Stats(); len(recorded) != 1 || recorded[0].Consumer != stats.Consumer {
+		t.Errorf("expected the statistics recorded, got %+v", recorded)
+	}
+
+	recorder.remove(stats.Consumer)
+	if recorded := recorder.Stats(); len(recorded) != 0 {
+		t.Errorf("expected the statistics removed, got %+v", recorded)
+	}
+
+	var none *StatsRecorder
+	none.record(stats)
+	if none.Stats() != nil {
+		t.Errorf("expected a nil recorder to record nothing")
+	}
+}
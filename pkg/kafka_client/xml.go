@@ -0,0 +1,136 @@
+package kafka_client
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Maximum nesting of the XML elements, guarding against malicious messages.
+const XML_MAX_DEPTH = 64
+
+// Keys of the attributes, prefixed by XML_ATTRIBUTE_PREFIX, and of the text
+// of the elements which also hold attributes or children. Neither can clash
+// with the name of an element.
+const XML_ATTRIBUTE_PREFIX = "@"
+const XML_TEXT_KEY = "#text"
+
+// decodeXML decodes an XML document into a flattened record, typed like the
+// CSV records: the numeric values as float64 and the others as strings. The
+// children of the root element are its fields, e.g.
+// <reading unit="ms"><host>a</host><latency>5</latency></reading> becomes
+// {"@unit": "ms", "host": "a", "latency": 5}. The repeated elements become
+// arrays and the namespaces are left out.
+func decodeXML(value []byte) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(value))
+	// The documents declaring their encoding are transcoded by the decoder,
+	// the others were transcoded from the charset of the datasource.
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		charset, err := lookupCharset(label)
+		if err != nil || charset == nil {
+			return input, err
+		}
+		return charset.NewDecoder().Reader(input), nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("no XML element in the message")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		root, err := decodeXMLElement(decoder, start, 0)
+		if err != nil {
+			return nil, err
+		}
+		record, ok := root.(map[string]interface{})
+		if !ok {
+			// A root element holding text only.
+			record = map[string]interface{}{start.Name.Local: root}
+		}
+		out := make(map[string]interface{}, len(record))
+		flatten("", record, out)
+
+		return out, nil
+	}
+}
+
+// declaresXMLEncoding tells whether the document starts with an XML
+// declaration giving its encoding, e.g. <?xml version="1.0" encoding="ISO-8859-1"?>.
+func declaresXMLEncoding(value []byte) bool {
+	value = bytes.TrimLeft(value, " \t\r\n")
+	if !bytes.HasPrefix(value, []byte("<?xml")) {
+		return false
+	}
+	end := bytes.Index(value, []byte("?>"))
+	return end >= 0 && bytes.Contains(value[:end], []byte("encoding"))
+}
+
+// decodeXMLElement decodes the element up to its end: a map of its
+// attributes and children, or its text when it has neither.
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement, depth int) (interface{}, error) {
+	if depth > XML_MAX_DEPTH {
+		return nil, errors.New("XML elements nested too deeply")
+	}
+
+	element := make(map[string]interface{}, len(start.Attr))
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		element[XML_ATTRIBUTE_PREFIX+attr.Name.Local] = xmlScalar(attr.Value)
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := element[name].(type) {
+			case nil:
+				element[name] = child
+			case []interface{}:
+				element[name] = append(existing, child)
+			default:
+				element[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return xmlScalar(s), nil
+			}
+			if s != "" {
+				element[XML_TEXT_KEY] = xmlScalar(s)
+			}
+			return element, nil
+		}
+	}
+}
+
+// xmlScalar keeps the numeric values as numbers and the others as strings.
+func xmlScalar(s string) interface{} {
+	if number, err := strconv.ParseFloat(s, 64); err == nil {
+		return number
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package kafka_client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestDecodeXML(t *testing.T) {
+	value := []byte(`<?xml version="1.0" encoding="ISO-8859-1"?>
+<reading xmlns="urn:sensors" id="7">
+	<host>a</host>
+	<cpu>0.5</cpu>
+	<latency unit="ms">5</latency>
+	<tags><dc>eu</dc></tags>
+	<empty/>
+	<sample>1</sample>
+	<sample>2</sample>
+</reading>`)
+	expected := map[string]interface{}{
+		"@id":           7.0,
+		"host":          "a",
+		"cpu":           0.5,
+		"latency.@unit": "ms",
+		"latency.#text": 5.0,
+		"tags.dc":       "eu",
+		"empty":         "",
+		"sample":        []interface{}{1.0, 2.0},
+	}
+
+	records, err := decodeValue(value, DecodeOptions{Format: FORMAT_XML})
+	if err != nil || !reflect.DeepEqual(records, []map[string]interface{}{expected}) {
+		t.Errorf("expected %v, got %v (%v)", expected, records, err)
+	}
+
+	if record, err := decodeXML([]byte(`<count>3</count>`)); err != nil || record["count"] != 3.0 {
+		t.Errorf("expected a text root to be a field, got %v (%v)", record, err)
+	}
+	if _, err := decodeXML([]byte(`<reading><host>a</reading>`)); err == nil {
+		t.Errorf("expected a malformed document to fail")
+	}
+	if _, err := decodeXML([]byte(`not xml`)); err == nil {
+		t.Errorf("expected a message without element to fail")
+	}
+}
+
+func TestDecodeXMLDeclaredEncoding(t *testing.T) {
+	value := []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><reading><city>M\xfcnchen</city></reading>")
+	client := NewKafkaClient(Options{})
+	client.Decode = DecodeOptions{Format: FORMAT_XML}
+
+	message := client.newConsumedMessage(&kafka.Message{Value: value})
+	if message.DecodeError != nil || len(message.Values) != 1 || message.Values[0]["city"] != "München" {
+		t.Errorf("expected the document to be decoded from its encoding, got %v (%v)", message.Values, message.DecodeError)
+	}
+	if isTranscoded(FORMAT_XML, value) || !isTranscoded(FORMAT_XML, []byte("<city>München</city>")) {
+		t.Errorf("expected only the documents without declared encoding to be transcoded by the datasource")
+	}
+}
@@ -2,15 +2,46 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/hoptical/grafana-kafka-datasource/pkg/plugin"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
-	if err := datasource.Manage("hamedkarbasi93-kafka-datasource", plugin.NewKafkaInstance, datasource.ManageOpts{}); err != nil {
-		log.DefaultLogger.Error(err.Error())
-		os.Exit(1)
+	// Close the consumers before exiting, so that they leave their groups
+	// instead of being dropped after the session timeout.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	stopped := make(chan bool, 1)
+	go func() {
+		<-signals
+		stopped <- plugin.Shutdown(plugin.SHUTDOWN_TIMEOUT)
+	}()
+
+	// The datasources serve the metrics of the plugin with CollectMetrics,
+	// which this version of the SDK doesn't route to them: it serves the
+	// default registry, which gathers them as well.
+	prometheus.MustRegister(plugin.MetricsCollectors()...)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- datasource.Manage("hamedkarbasi93-kafka-datasource", plugin.NewKafkaInstance, datasource.ManageOpts{})
+	}()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			log.DefaultLogger.Error(err.Error())
+			os.Exit(1)
+		}
+	case clean := <-stopped:
+		// Some consumers were still open when the shutdown timed out.
+		if !clean {
+			os.Exit(1)
+		}
 	}
 }
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// connectionError is the last error connecting to the brokers.
+type connectionError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// recordError keeps the error for the diagnostics.
+func (d *KafkaDatasource) recordError(err error) {
+	d.errorMu.Lock()
+	defer d.errorMu.Unlock()
+
+	d.lastError = &connectionError{Time: time.Now().UTC(), Message: err.Error()}
+}
+
+// diagnosticsReport describes the datasource for the bug reports, without
+// any of its secrets.
+type diagnosticsReport struct {
+	PluginVersion     string                 `json:"pluginVersion"`
+	LibrdkafkaVersion string                 `json:"librdkafkaVersion"`
+	Settings          kafka_client.Options   `json:"settings"`
+	ConsumerConfig    map[string]interface{} `json:"consumerConfig"`
+	ActiveStreams     int                    `json:"activeStreams"`
+	PooledConsumers   int                    `json:"pooledConsumers"`
+	LastError         *connectionError       `json:"lastError"`
+	// Last librdkafka statistics of the stream consumers, when enabled.
+	Statistics []kafka_client.ConsumerStats `json:"statistics"`
+}
+
+// diagnostics reports the effective settings of the datasource, defaults
+// included, along with the librdkafka settings they resolve to and the last
+// connection error.
+func (d *KafkaDatasource) diagnostics() diagnosticsReport {
+	settings := d.settings.Redacted()
+	client := kafka_client.NewKafkaClient(settings)
+
+	d.streamsMu.Lock()
+	streams := len(d.streams)
+	d.streamsMu.Unlock()
+
+	d.errorMu.Lock()
+	defer d.errorMu.Unlock()
+
+	return diagnosticsReport{
+		PluginVersion:     pluginVersion(),
+		LibrdkafkaVersion: kafka_client.LibraryVersion(),
+		Settings:          settings,
+		ConsumerConfig:    client.ConsumerConfig(),
+		ActiveStreams:     streams,
+		PooledConsumers:   d.pool.Size(),
+		LastError:         d.lastError,
+		Statistics:        d.stats.Stats(),
+	}
+}
@@ -0,0 +1,611 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// frameRow holds the fields of a message to show in a frame.
+type frameRow struct {
+	time   time.Time
+	values map[string]interface{}
+	// Position of the message of the row, used to sort and group the batches.
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// messageRows turns the records of a message into rows, keeping the fields
+// which pass the query filters under their alias. The time of a row is the
+// time field of its record when the query sets one.
+func messageRows(msg *kafka_client.ConsumedMessage, rowTime time.Time, qm queryModel) []frameRow {
+	rows := make([]frameRow, 0, len(msg.Values))
+	for _, record := range msg.Values {
+		recordTime := rowTime
+		if qm.TimeField != "" {
+			if t, ok := parseTime(record[qm.TimeField], qm.TimeFieldFormat); ok {
+				recordTime = t
+			}
+		}
+		for _, expanded := range expandSeries(record, recordTime, qm) {
+			row := frameRow{
+				time:      expanded.time,
+				values:    make(map[string]interface{}, len(expanded.values)),
+				topic:     msg.Topic,
+				partition: msg.Partition,
+				offset:    msg.Offset,
+			}
+			for key, value := range pivot(expanded.values, qm) {
+				if key == qm.TimeField {
+					continue
+				}
+				if v, ok := value.(float64); ok && outOfRange(key, v, qm) {
+					continue
+				}
+				if contains(qm.TimestampFields, key) {
+					t, ok := parseTimestamp(value)
+					if !ok {
+						continue
+					}
+					value = t
+				}
+				if qm.EventMode {
+					value = eventValue(value)
+				}
+				if s, ok := value.(string); ok {
+					value = truncate(s, qm.MaxStringLength)
+				}
+				if fieldAllowed(key, qm.IncludeFields, qm.ExcludeFields) {
+					row.values[fieldName(key, qm.FieldAliases)] = value
+				}
+			}
+			if qm.IncludeRaw {
+				row.values[RAW_FIELD] = truncate(string(msg.Value), qm.MaxStringLength)
+			}
+			if qm.IncludeMetadata {
+				row.values[TOPIC_FIELD] = msg.Topic
+				row.values[PARTITION_FIELD] = float64(msg.Partition)
+				row.values[OFFSET_FIELD] = float64(msg.Offset)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// Field of the raw value of the message of a row, added when the query
+// includes it.
+const RAW_FIELD = "__raw"
+
+// Field of the sequence number of the message of a row within its stream,
+// added when the query includes it.
+const SEQ_FIELD = "__seq"
+
+// setSeq numbers the rows of a message with its sequence number.
+func setSeq(rows []frameRow, seq int64) {
+	for _, row := range rows {
+		row.values[SEQ_FIELD] = float64(seq)
+	}
+}
+
+// eventValue encodes the arrays of the events as JSON text, which the frames
+// cannot hold otherwise.
+func eventValue(value interface{}) interface{} {
+	array, ok := value.([]interface{})
+	if !ok {
+		return value
+	}
+	encoded, err := json.Marshal(array)
+	if err != nil {
+		return nil
+	}
+	return string(encoded)
+}
+
+// Marker appended to the truncated strings.
+const TRUNCATION_MARKER = "…"
+
+// truncate cuts the string to its first max characters followed by the
+// marker, leaving it as is when max is 0.
+func truncate(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	runes := []rune(s)
+
+	return string(runes[:max]) + TRUNCATION_MARKER
+}
+
+// Fields of the position of the message of a row, added when the query
+// includes the metadata.
+const TOPIC_FIELD = "__topic"
+const PARTITION_FIELD = "__partition"
+const OFFSET_FIELD = "__offset"
+
+// setOffsetLinks links the values of the frame to the preview of their
+// message, found by the metadata fields of their row. The rows of a frame
+// merging the topics of a pattern take their topic from the topic field,
+// percent-encoded by Grafana. The links of a query overriding the bootstrap
+// servers preview the messages of its servers.
+func setOffsetLinks(frame *data.Frame, uid string, topic string, servers string) {
+	topicParam := url.QueryEscape(topic)
+	if strings.HasPrefix(topic, "^") {
+		topicParam = fmt.Sprintf("${__data.fields.%s:percentencode}", TOPIC_FIELD)
+	}
+	previewURL := fmt.Sprintf("/api/datasources/uid/%s/resources/preview?topic=%s&partition=${__data.fields.%s}&offset=${__data.fields.%s}&n=1",
+		uid, topicParam, PARTITION_FIELD, OFFSET_FIELD)
+	if servers != "" {
+		previewURL += "&bootstrapServers=" + url.QueryEscape(servers)
+	}
+	link := data.DataLink{
+		Title:       "Preview message",
+		URL:         previewURL,
+		TargetBlank: true,
+	}
+	for _, field := range frame.Fields {
+		switch field.Name {
+		case "time", TOPIC_FIELD, PARTITION_FIELD, OFFSET_FIELD:
+			continue
+		}
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		field.Config.Links = append(field.Config.Links, link)
+	}
+}
+
+// Grouping of the rows of the topics matched by a pattern: FRAME_MODE_MERGED,
+// the default, sends the rows of all the topics in a frame, while
+// FRAME_MODE_PER_TOPIC sends a frame per topic, named after it.
+const FRAME_MODE_MERGED = "merged"
+const FRAME_MODE_PER_TOPIC = "perTopic"
+
+var FRAME_MODES = []string{FRAME_MODE_MERGED, FRAME_MODE_PER_TOPIC}
+
+// streamFrames groups the rows of a batch into frames according to the frame
+// mode. The rows without topic, like the aggregated ones, go to the frame of
+// the given name. Every frame carries the status of the stream, along with
+// the topic and the last offset of its messages.
+func streamFrames(rows []frameRow, name string, frameMode string, status streamStatus) []*data.Frame {
+	if frameMode != FRAME_MODE_PER_TOPIC {
+		return []*data.Frame{withStatus(newFrame(name, rows), rows, status)}
+	}
+
+	var topics []string
+	rowsByTopic := make(map[string][]frameRow)
+	for _, row := range rows {
+		if _, exists := rowsByTopic[row.topic]; !exists {
+			topics = append(topics, row.topic)
+		}
+		rowsByTopic[row.topic] = append(rowsByTopic[row.topic], row)
+	}
+
+	frames := make([]*data.Frame, len(topics))
+	for i, topic := range topics {
+		topicStatus, topicName := status, name
+		if topic != "" {
+			topicStatus.Topic, topicName = topic, topic
+		}
+		frames[i] = withStatus(newFrame(topicName, rowsByTopic[topic]), rowsByTopic[topic], topicStatus)
+	}
+
+	return frames
+}
+
+// withStatus sets the status in the metadata of the frame of the rows.
+func withStatus(frame *data.Frame, rows []frameRow, status streamStatus) *data.Frame {
+	last := int64(-1)
+	for _, row := range rows {
+		if row.topic != "" && row.offset > last {
+			last = row.offset
+		}
+	}
+	if last >= 0 {
+		status.Offset = fmt.Sprint(last)
+	}
+
+	return frame.SetMeta(&data.FrameMeta{Custom: status})
+}
+
+// pivot names the value field of a record after the value of its name field,
+// e.g. {"metric": "cpu", "value": 0.5} becomes {"cpu": 0.5}, so that a topic
+// fans out into a series per name. The other fields are kept.
+func pivot(record map[string]interface{}, qm queryModel) map[string]interface{} {
+	if qm.NameField == "" || qm.ValueField == "" {
+		return record
+	}
+	value, exists := record[qm.ValueField]
+	if !exists || record[qm.NameField] == nil {
+		return record
+	}
+	name := fmt.Sprint(record[qm.NameField])
+	if name == "" {
+		return record
+	}
+
+	pivoted := make(map[string]interface{}, len(record)-1)
+	for key, v := range record {
+		if key != qm.NameField && key != qm.ValueField {
+			pivoted[key] = v
+		}
+	}
+	pivoted[name] = value
+
+	return pivoted
+}
+
+// Orders of the rows of a frame. SORT_BY_TIME, the default, keeps the lines
+// of the panels from going back and forth when a batch holds messages of
+// several partitions, SORT_BY_OFFSET shows the messages in the order of the
+// partitions and SORT_BY_NONE in their arrival order.
+const SORT_BY_TIME = "time"
+const SORT_BY_OFFSET = "offset"
+const SORT_BY_NONE = "none"
+
+var SORT_BY = []string{SORT_BY_TIME, SORT_BY_OFFSET, SORT_BY_NONE}
+
+// sortRows sorts the rows in place, keeping the order of equal rows.
+func sortRows(rows []frameRow, sortBy string) []frameRow {
+	switch sortBy {
+	case SORT_BY_NONE:
+	case SORT_BY_OFFSET:
+		sort.SliceStable(rows, func(i, j int) bool {
+			if rows[i].partition != rows[j].partition {
+				return rows[i].partition < rows[j].partition
+			}
+			return rows[i].offset < rows[j].offset
+		})
+	default:
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].time.Before(rows[j].time)
+		})
+	}
+
+	return rows
+}
+
+// Default maximum number of distinct fields of a stream.
+const DEFAULT_MAX_FIELDS = 100
+
+// OVERFLOW_FIELD counts the fields of a row left out by the field limiter.
+const OVERFLOW_FIELD = "__overflow"
+
+// fieldLimiter caps the number of distinct fields of a stream, so that high
+// cardinality messages don't grow the frame schema without bounds. The
+// fields seen first are kept, the new ones beyond the limit being counted in
+// OVERFLOW_FIELD instead.
+type fieldLimiter struct {
+	max   int
+	known map[string]bool
+}
+
+func newFieldLimiter(max int) *fieldLimiter {
+	if max <= 0 {
+		max = DEFAULT_MAX_FIELDS
+	}
+	return &fieldLimiter{max: max, known: make(map[string]bool)}
+}
+
+// limit drops the fields of the row beyond the limit and returns how many
+// were dropped.
+func (l *fieldLimiter) limit(row frameRow) int {
+	keys := make([]string, 0, len(row.values))
+	for key := range row.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	overflow := 0
+	for _, key := range keys {
+		if l.known[key] {
+			continue
+		}
+		if len(l.known) < l.max {
+			l.known[key] = true
+			continue
+		}
+		delete(row.values, key)
+		overflow++
+	}
+	if overflow > 0 {
+		row.values[OVERFLOW_FIELD] = float64(overflow)
+	}
+
+	return overflow
+}
+
+// expandSeries expands a record carrying a time series as parallel arrays of
+// times and values into a row per point, repeating the other fields of the
+// record on every row. Records without the series make a single row.
+func expandSeries(record map[string]interface{}, rowTime time.Time, qm queryModel) []frameRow {
+	points, ok := record[qm.SeriesValueField].([]interface{})
+	if qm.SeriesValueField == "" || !ok {
+		return []frameRow{{time: rowTime, values: record}}
+	}
+	times, _ := record[qm.SeriesTimeField].([]interface{})
+
+	rows := make([]frameRow, len(points))
+	for i, point := range points {
+		values := make(map[string]interface{}, len(record))
+		for key, value := range record {
+			if key != qm.SeriesTimeField && key != qm.SeriesValueField {
+				values[key] = value
+			}
+		}
+		values[qm.SeriesValueField] = point
+
+		rows[i] = frameRow{time: rowTime, values: values}
+		if i < len(times) {
+			if pointTime, ok := parseTime(times[i], qm.TimeFieldFormat); ok {
+				rows[i].time = pointTime
+			}
+		}
+	}
+
+	return rows
+}
+
+// Formats of the time fields. TIME_FIELD_FORMAT_AUTO, the default, reads
+// RFC 3339 strings and guesses the unit of the epochs from their magnitude.
+const TIME_FIELD_FORMAT_AUTO = "auto"
+const TIME_FIELD_FORMAT_MS = "ms"
+const TIME_FIELD_FORMAT_S = "s"
+const TIME_FIELD_FORMAT_US = "us"
+const TIME_FIELD_FORMAT_NS = "ns"
+const TIME_FIELD_FORMAT_RFC3339 = "rfc3339"
+
+var TIME_FIELD_FORMATS = []string{
+	TIME_FIELD_FORMAT_AUTO,
+	TIME_FIELD_FORMAT_MS,
+	TIME_FIELD_FORMAT_S,
+	TIME_FIELD_FORMAT_US,
+	TIME_FIELD_FORMAT_NS,
+	TIME_FIELD_FORMAT_RFC3339,
+}
+
+// parseTime reads a time field, either an epoch, possibly as a string, or an
+// RFC 3339 string. The integer epochs given as strings are read exactly,
+// while the numbers lose the last digits of the nanosecond epochs to their
+// float64 precision, a few hundred nanoseconds.
+func parseTime(value interface{}, format string) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return epochTime(v, format)
+	case string:
+		if format == "" || format == TIME_FIELD_FORMAT_AUTO || format == TIME_FIELD_FORMAT_RFC3339 {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil || format == TIME_FIELD_FORMAT_RFC3339 {
+				return t, err == nil
+			}
+		}
+		v = strings.TrimSpace(v)
+		if epoch, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return epochIntTime(epoch, format)
+		}
+		epoch, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return epochTime(epoch, format)
+	default:
+		return time.Time{}, false
+	}
+}
+
+// outOfRange tells whether a value is outside the clamp range of its field,
+// like the sentinel values of sensors, left out of the rows.
+func outOfRange(key string, value float64, qm queryModel) bool {
+	if min, exists := qm.ClampMin[key]; exists && value < min {
+		return true
+	}
+	if max, exists := qm.ClampMax[key]; exists && value > max {
+		return true
+	}
+	return false
+}
+
+// Layouts of the ISO 8601 timestamps of the timestamp fields, the ones
+// without offset being in UTC.
+var TIMESTAMP_LAYOUTS = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseTimestamp parses the ISO 8601 timestamp of a timestamp field.
+func parseTimestamp(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, layout := range TIMESTAMP_LAYOUTS {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// epochFormatUnit returns the unit of the epochs of the format, 0 when the
+// format guesses it.
+func epochFormatUnit(format string) time.Duration {
+	switch format {
+	case TIME_FIELD_FORMAT_S:
+		return time.Second
+	case TIME_FIELD_FORMAT_MS:
+		return time.Millisecond
+	case TIME_FIELD_FORMAT_US:
+		return time.Microsecond
+	case TIME_FIELD_FORMAT_NS:
+		return time.Nanosecond
+	default:
+		return 0
+	}
+}
+
+func epochTime(epoch float64, format string) (time.Time, bool) {
+	if format == TIME_FIELD_FORMAT_RFC3339 || math.IsNaN(epoch) || math.IsInf(epoch, 0) {
+		return time.Time{}, false
+	}
+	unit := epochFormatUnit(format)
+	if unit == 0 {
+		unit = epochUnit(epoch)
+	}
+
+	// Splitting off the seconds keeps the epochs in seconds beyond 2262 from
+	// overflowing the nanoseconds.
+	perSecond := float64(time.Second / unit)
+	seconds := math.Floor(epoch / perSecond)
+	nanoseconds := (epoch - seconds*perSecond) * float64(unit)
+
+	return time.Unix(int64(seconds), int64(math.Round(nanoseconds))), true
+}
+
+// epochIntTime converts an integer epoch without losing any digit.
+func epochIntTime(epoch int64, format string) (time.Time, bool) {
+	if format == TIME_FIELD_FORMAT_RFC3339 {
+		return time.Time{}, false
+	}
+	unit := epochFormatUnit(format)
+	if unit == 0 {
+		unit = epochUnit(float64(epoch))
+	}
+
+	perSecond := int64(time.Second / unit)
+	return time.Unix(epoch/perSecond, epoch%perSecond*int64(unit)), true
+}
+
+// epochUnit guesses the unit of an epoch: the current time is around 1.7e9
+// seconds, 1.7e12 milliseconds, 1.7e15 microseconds and 1.7e18 nanoseconds,
+// so the thresholds between them sit in the middle of the gaps.
+func epochUnit(epoch float64) time.Duration {
+	magnitude := math.Abs(epoch)
+	switch {
+	case magnitude < 1e11:
+		return time.Second
+	case magnitude < 1e14:
+		return time.Millisecond
+	case magnitude < 1e17:
+		return time.Microsecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+// newFrame builds a frame with a row per message. Messages don't necessarily
+// share the same fields, so every field is nullable and the cells of the
+// messages lacking it stay null rather than reading as a false zero. The
+// fields are sorted by name, so that the series keep their order, and their
+// colors, from a frame to the next.
+func newFrame(name string, rows []frameRow) *data.Frame {
+	times := make([]time.Time, len(rows))
+	var fields []*data.Field
+	fieldsByName := make(map[string]*data.Field)
+
+	for i, row := range rows {
+		times[i] = row.time
+		for key, value := range row.values {
+			field, exists := fieldsByName[key]
+			if !exists {
+				field = newNullableField(key, value, len(rows))
+				if field == nil {
+					continue
+				}
+				fieldsByName[key] = field
+				fields = append(fields, field)
+			}
+			setNullable(field, i, value)
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	frame := data.NewFrame(name, data.NewField("time", nil, times))
+	frame.Fields = append(frame.Fields, fields...)
+
+	return frame
+}
+
+// newNullableField creates a field typed after the first value seen for it,
+// or returns nil for values that cannot be shown, like arrays.
+func newNullableField(name string, value interface{}, length int) *data.Field {
+	switch value.(type) {
+	case float64:
+		return data.NewField(name, nil, make([]*float64, length))
+	case string:
+		return data.NewField(name, nil, make([]*string, length))
+	case bool:
+		return data.NewField(name, nil, make([]*bool, length))
+	case time.Time:
+		return data.NewField(name, nil, make([]*time.Time, length))
+	default:
+		return nil
+	}
+}
+
+// setNullable sets a cell, leaving it null when the value doesn't match the
+// field type.
+func setNullable(field *data.Field, i int, value interface{}) {
+	switch v := value.(type) {
+	case float64:
+		if field.Type() == data.FieldTypeNullableFloat64 {
+			field.Set(i, &v)
+		}
+	case string:
+		if field.Type() == data.FieldTypeNullableString {
+			field.Set(i, &v)
+		}
+	case bool:
+		if field.Type() == data.FieldTypeNullableBool {
+			field.Set(i, &v)
+		}
+	case time.Time:
+		if field.Type() == data.FieldTypeNullableTime {
+			field.Set(i, &v)
+		}
+	}
+}
+
+// fieldName returns the alias of a field, or its name when it has none.
+func fieldName(name string, aliases map[string]string) string {
+	if alias, exists := aliases[name]; exists && alias != "" {
+		return alias
+	}
+	return name
+}
+
+// fieldAllowed tells whether a message field passes the query's glob
+// patterns: it must match an include pattern, if any are set, and none of
+// the exclude patterns.
+func fieldAllowed(name string, include []string, exclude []string) bool {
+	if len(include) > 0 && !matchAny(name, include) {
+		return false
+	}
+
+	return !matchAny(name, exclude)
+}
+
+func matchAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,428 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestExpandSeries(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	qm := queryModel{SeriesTimeField: "t", SeriesValueField: "v"}
+	record := map[string]interface{}{
+		"t":    []interface{}{1640995200000.0, "2022-01-01T00:00:01Z"},
+		"v":    []interface{}{1.0, 2.0},
+		"host": "a",
+	}
+
+	rows := expandSeries(record, now, qm)
+	if len(rows) != 2 {
+		t.Fatalf("expected a row per point, got %d rows", len(rows))
+	}
+	if !rows[0].time.Equal(now) || !rows[1].time.Equal(now.Add(time.Second)) {
+		t.Errorf("unexpected times %v and %v", rows[0].time, rows[1].time)
+	}
+	if rows[1].values["v"] != 2.0 || rows[1].values["host"] != "a" {
+		t.Errorf("unexpected values %v", rows[1].values)
+	}
+	if _, exists := rows[0].values["t"]; exists {
+		t.Errorf("expected the time array to be dropped, got %v", rows[0].values)
+	}
+
+	rows = expandSeries(map[string]interface{}{"v": 3.0}, now, qm)
+	if len(rows) != 1 || rows[0].values["v"] != 3.0 {
+		t.Errorf("expected a record without series to be kept, got %v", rows)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  interface{}
+		format string
+	}{
+		{1640995200.0, TIME_FIELD_FORMAT_AUTO},
+		{1640995200000.0, TIME_FIELD_FORMAT_AUTO},
+		{1640995200000000.0, TIME_FIELD_FORMAT_AUTO},
+		{1640995200000000000.0, ""},
+		{"1640995200", TIME_FIELD_FORMAT_AUTO},
+		{"2022-01-01T00:00:00Z", TIME_FIELD_FORMAT_AUTO},
+		{1640995200.0, TIME_FIELD_FORMAT_S},
+		{1640995200000.0, TIME_FIELD_FORMAT_MS},
+		{"2022-01-01T00:00:00Z", TIME_FIELD_FORMAT_RFC3339},
+	}
+
+	for _, test := range tests {
+		parsed, ok := parseTime(test.value, test.format)
+		if !ok || !parsed.Equal(expected) {
+			t.Errorf("%v as %q: expected %v, got %v", test.value, test.format, expected, parsed)
+		}
+	}
+
+	if _, ok := parseTime("1640995200", TIME_FIELD_FORMAT_RFC3339); ok {
+		t.Errorf("expected an epoch not to be read as RFC 3339")
+	}
+}
+
+func TestParseTimeUnits(t *testing.T) {
+	expected := time.Date(2022, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		value    interface{}
+		format   string
+		expected time.Time
+	}{
+		{"1640995200123456789", TIME_FIELD_FORMAT_AUTO, expected},
+		{"1640995200123456789", TIME_FIELD_FORMAT_NS, expected},
+		{"1640995200123456", TIME_FIELD_FORMAT_AUTO, expected.Truncate(time.Microsecond)},
+		{1640995200123456.0, TIME_FIELD_FORMAT_US, expected.Truncate(time.Microsecond)},
+		{"1640995200123", TIME_FIELD_FORMAT_AUTO, expected.Truncate(time.Millisecond)},
+		{1640995200123.0, TIME_FIELD_FORMAT_AUTO, expected.Truncate(time.Millisecond)},
+		{1640995200.5, TIME_FIELD_FORMAT_S, expected.Truncate(time.Second).Add(500 * time.Millisecond)},
+		{"1640995200", TIME_FIELD_FORMAT_MS, time.Date(1970, 1, 19, 23, 49, 55, 200000000, time.UTC)},
+		{"32503680000", TIME_FIELD_FORMAT_AUTO, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		parsed, ok := parseTime(test.value, test.format)
+		if !ok || !parsed.Equal(test.expected) {
+			t.Errorf("%v as %q: expected %v, got %v", test.value, test.format, test.expected, parsed)
+		}
+	}
+
+	if parsed, ok := parseTime(1640995200123456789.0, TIME_FIELD_FORMAT_AUTO); !ok || math.Abs(float64(parsed.Sub(expected))) > float64(time.Microsecond) {
+		t.Errorf("expected a nanosecond epoch number within a microsecond, got %v", parsed)
+	}
+}
+
+func TestPivot(t *testing.T) {
+	qm := queryModel{NameField: "metric", ValueField: "value"}
+
+	pivoted := pivot(map[string]interface{}{"metric": "cpu", "value": 0.5, "host": "a"}, qm)
+	if len(pivoted) != 2 || pivoted["cpu"] != 0.5 || pivoted["host"] != "a" {
+		t.Errorf("unexpected pivoted record %v", pivoted)
+	}
+
+	record := map[string]interface{}{"value": 0.5}
+	if pivoted := pivot(record, qm); len(pivoted) != 1 || pivoted["value"] != 0.5 {
+		t.Errorf("expected a record without name to be kept, got %v", pivoted)
+	}
+}
+
+func TestMessageRowsIncludeRaw(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"cpu": 0.5}},
+		Value:  []byte(`{"cpu": 0.5}`),
+	}
+
+	rows := messageRows(msg, time.Now(), queryModel{IncludeRaw: true})
+	if len(rows) != 1 || rows[0].values["cpu"] != 0.5 || rows[0].values[RAW_FIELD] != `{"cpu": 0.5}` {
+		t.Errorf("expected the raw value next to the fields, got %v", rows)
+	}
+	if rows := messageRows(msg, time.Now(), queryModel{}); len(rows[0].values) != 1 {
+		t.Errorf("expected no raw value by default, got %v", rows[0].values)
+	}
+}
+
+func TestMessageRowsMaxStringLength(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"trace": "héllo world", "level": "warn", "count": 12.0}},
+		Value:  []byte(`{"trace": "héllo world"}`),
+	}
+
+	rows := messageRows(msg, time.Now(), queryModel{MaxStringLength: 5, IncludeRaw: true})
+	values := rows[0].values
+	if values["trace"] != "héllo…" || values["level"] != "warn" || values["count"] != 12.0 {
+		t.Errorf("expected the long strings truncated, got %v", values)
+	}
+	if values[RAW_FIELD] != `{"tra…` {
+		t.Errorf("expected the raw value truncated, got %v", values[RAW_FIELD])
+	}
+}
+
+func TestMessageRowsEventMode(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"level": "warn", "code": 42.0, "tags": []interface{}{"a", 1.0}}},
+	}
+
+	rows := messageRows(msg, time.Now(), queryModel{EventMode: true})
+	if values := rows[0].values; values["level"] != "warn" || values["code"] != 42.0 || values["tags"] != `["a",1]` {
+		t.Errorf("expected every field of the event, got %v", values)
+	}
+	frame := newFrame("events", rows)
+	if len(frame.Fields) != 4 {
+		t.Errorf("expected a field per column of the event, got %d", len(frame.Fields))
+	}
+
+	if err := (queryModel{Topic: "test", EventMode: true, Aggregation: AGGREGATION_AVG}).validate(); err == nil {
+		t.Errorf("expected the aggregations to be refused in event mode")
+	}
+}
+
+func TestMessageRowsTimestampFields(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"created": "2022-01-01T10:00:00Z", "updated": "2022-01-01 10:05:00", "expires": "never"}},
+	}
+
+	rows := messageRows(msg, time.Now(), queryModel{TimestampFields: []string{"created", "updated", "expires"}})
+	created := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	if v, ok := rows[0].values["created"].(time.Time); !ok || !v.Equal(created) {
+		t.Errorf("expected a time, got %v", rows[0].values["created"])
+	}
+	if v, ok := rows[0].values["updated"].(time.Time); !ok || !v.Equal(created.Add(5*time.Minute)) {
+		t.Errorf("expected a time without offset in UTC, got %v", rows[0].values["updated"])
+	}
+	if _, exists := rows[0].values["expires"]; exists {
+		t.Errorf("expected an invalid timestamp to be left out, got %v", rows[0].values["expires"])
+	}
+}
+
+func TestMessageRowsClamp(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"temp": -9999.0, "hum": 45.0, "pressure": 1013.0}},
+	}
+	qm := queryModel{ClampMin: map[string]float64{"temp": -50, "hum": 0}, ClampMax: map[string]float64{"hum": 100}}
+
+	rows := messageRows(msg, time.Now(), qm)
+	if _, exists := rows[0].values["temp"]; exists {
+		t.Errorf("expected the value below the minimum to be left out, got %v", rows[0].values)
+	}
+	if rows[0].values["hum"] != 45.0 || rows[0].values["pressure"] != 1013.0 {
+		t.Errorf("expected the values in range to be kept, got %v", rows[0].values)
+	}
+}
+
+// rowFields returns the sorted names of the fields of a row.
+func rowFields(values map[string]interface{}) []string {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestMessageRowsFieldFilters(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Values: []map[string]interface{}{{"metrics.cpu": 0.5, "metrics.mem": 0.25, "host": "a", "debug.trace": "x"}},
+	}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		expected []string
+	}{
+		{"no filter", nil, nil, []string{"debug.trace", "host", "metrics.cpu", "metrics.mem"}},
+		{"include glob", []string{"metrics.*"}, nil, []string{"metrics.cpu", "metrics.mem"}},
+		{"include several", []string{"metrics.cpu", "host"}, nil, []string{"host", "metrics.cpu"}},
+		{"exclude glob", nil, []string{"debug.*"}, []string{"host", "metrics.cpu", "metrics.mem"}},
+		{"exclude over include", []string{"metrics.*"}, []string{"*.mem"}, []string{"metrics.cpu"}},
+		{"no match", []string{"disk.*"}, nil, []string{}},
+	}
+
+	for _, test := range tests {
+		rows := messageRows(msg, time.Now(), queryModel{IncludeFields: test.include, ExcludeFields: test.exclude})
+		if fields := rowFields(rows[0].values); !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected the fields %v, got %v", test.name, test.expected, fields)
+		}
+	}
+}
+
+func TestMessageRowsFieldAliases(t *testing.T) {
+	msg := &kafka_client.ConsumedMessage{
+		Topic:  "test",
+		Values: []map[string]interface{}{{"v1": 12.0, "m2": 0.5, "host": "a"}},
+	}
+
+	tests := []struct {
+		name     string
+		qm       queryModel
+		expected map[string]interface{}
+	}{
+		{"no aliases", queryModel{}, map[string]interface{}{"v1": 12.0, "m2": 0.5, "host": "a"}},
+		{"renamed", queryModel{FieldAliases: map[string]string{"v1": "Latency (ms)", "m2": "Load"}},
+			map[string]interface{}{"Latency (ms)": 12.0, "Load": 0.5, "host": "a"}},
+		{"empty alias", queryModel{FieldAliases: map[string]string{"v1": ""}},
+			map[string]interface{}{"v1": 12.0, "m2": 0.5, "host": "a"}},
+		{"filtered by original name", queryModel{FieldAliases: map[string]string{"v1": "Latency (ms)"}, IncludeFields: []string{"v*"}},
+			map[string]interface{}{"Latency (ms)": 12.0}},
+		{"metadata kept", queryModel{FieldAliases: map[string]string{TOPIC_FIELD: "Topic"}, IncludeFields: []string{"host"}, IncludeMetadata: true},
+			map[string]interface{}{"host": "a", TOPIC_FIELD: "test", PARTITION_FIELD: 0.0, OFFSET_FIELD: 0.0}},
+	}
+
+	for _, test := range tests {
+		rows := messageRows(msg, time.Now(), test.qm)
+		if !reflect.DeepEqual(rows[0].values, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, rows[0].values)
+		}
+	}
+}
+
+func TestSetSeq(t *testing.T) {
+	messages := []*kafka_client.ConsumedMessage{
+		{Partition: 0, Offset: 7, Values: []map[string]interface{}{{"v": 1.0}}},
+		{Partition: 1, Offset: 3, Values: []map[string]interface{}{{"v": 2.0}, {"v": 3.0}}},
+		{Partition: 0, Offset: 8, Values: []map[string]interface{}{{"v": 4.0}}},
+	}
+
+	var seqs []interface{}
+	for i, msg := range messages {
+		rows := messageRows(msg, time.Now(), queryModel{})
+		setSeq(rows, int64(i+1))
+		for _, row := range rows {
+			seqs = append(seqs, row.values[SEQ_FIELD])
+		}
+	}
+	// The records of a message share its number, continuous across the
+	// partitions.
+	if expected := []interface{}{1.0, 2.0, 2.0, 3.0}; !reflect.DeepEqual(seqs, expected) {
+		t.Errorf("expected the sequence numbers %v, got %v", expected, seqs)
+	}
+}
+
+func TestMessagesFrameTombstones(t *testing.T) {
+	messages := []*kafka_client.ConsumedMessage{
+		{Offset: 1, Values: []map[string]interface{}{{"state": "active"}}},
+		{Offset: 2, Tombstone: true, Values: []map[string]interface{}{{kafka_client.TOMBSTONE_FIELD: true, kafka_client.TOMBSTONE_KEY_FIELD: "user-1"}}},
+	}
+
+	for _, test := range []struct {
+		skip bool
+		rows int
+	}{{false, 2}, {true, 1}} {
+		frame := messagesFrame(messages, queryModel{Topic: "test", SkipTombstones: test.skip})
+		if rows, _ := frame.RowLen(); rows != test.rows {
+			t.Errorf("skip %v: expected %d rows, got %d", test.skip, test.rows, rows)
+		}
+		tombstoneField := false
+		for _, field := range frame.Fields {
+			tombstoneField = tombstoneField || field.Name == kafka_client.TOMBSTONE_FIELD
+		}
+		if tombstoneField == test.skip {
+			t.Errorf("skip %v: expected the tombstone field only with the tombstones", test.skip)
+		}
+	}
+}
+
+func TestSortRows(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := []frameRow{
+		{time: start.Add(2 * time.Second), partition: 0, offset: 7},
+		{time: start, partition: 1, offset: 3},
+		{time: start.Add(time.Second), partition: 0, offset: 6},
+	}
+
+	sortRows(rows, SORT_BY_TIME)
+	if rows[0].offset != 3 || rows[1].offset != 6 || rows[2].offset != 7 {
+		t.Errorf("expected the rows sorted by time, got %v", rows)
+	}
+	sortRows(rows, SORT_BY_OFFSET)
+	if rows[0].offset != 6 || rows[1].offset != 7 || rows[2].offset != 3 {
+		t.Errorf("expected the rows sorted by partition and offset, got %v", rows)
+	}
+}
+
+func TestStreamFrames(t *testing.T) {
+	rows := []frameRow{
+		{topic: "metrics-a", offset: 4, values: map[string]interface{}{"v": 1.0}},
+		{topic: "metrics-b", offset: 9, values: map[string]interface{}{"v": 2.0}},
+		{topic: "metrics-a", offset: 5, values: map[string]interface{}{"v": 3.0}},
+	}
+
+	status := streamStatus{Status: "streaming", Topic: "^metrics-.*", Partition: -1}
+
+	frames := streamFrames(rows, "metrics", FRAME_MODE_MERGED, status)
+	if len(frames) != 1 || frames[0].Name != "metrics" {
+		t.Fatalf("expected a merged frame, got %v", frames)
+	}
+	if custom := frames[0].Meta.Custom.(streamStatus); custom.Topic != "^metrics-.*" || custom.Offset != "9" {
+		t.Errorf("unexpected merged status %+v", custom)
+	}
+
+	frames = streamFrames(rows, "metrics", FRAME_MODE_PER_TOPIC, status)
+	if len(frames) != 2 || frames[0].Name != "metrics-a" || frames[1].Name != "metrics-b" {
+		t.Fatalf("expected a frame per topic, got %v", frames)
+	}
+	if custom := frames[0].Meta.Custom.(streamStatus); custom.Topic != "metrics-a" || custom.Offset != "5" {
+		t.Errorf("unexpected status %+v of topic metrics-a", custom)
+	}
+}
+
+func TestNewFrameFieldOrder(t *testing.T) {
+	rows := []frameRow{
+		{values: map[string]interface{}{"mem": 1.0, "cpu": 2.0, "host": "a", "disk": 3.0}},
+		{values: map[string]interface{}{"net": 4.0, "cpu": 5.0}},
+	}
+
+	for i := 0; i < 10; i++ {
+		frame := newFrame("test", rows)
+		var names []string
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		if strings.Join(names, ",") != "time,cpu,disk,host,mem,net" {
+			t.Fatalf("expected the fields sorted by name, got %v", names)
+		}
+	}
+}
+
+func TestFieldLimiter(t *testing.T) {
+	fields := newFieldLimiter(2)
+
+	first := frameRow{values: map[string]interface{}{"a": 1.0, "b": 2.0}}
+	if overflow := fields.limit(first); overflow != 0 {
+		t.Errorf("expected no overflow, got %d", overflow)
+	}
+
+	second := frameRow{values: map[string]interface{}{"a": 1.0, "c": 3.0, "d": 4.0}}
+	if overflow := fields.limit(second); overflow != 2 {
+		t.Errorf("expected 2 fields to overflow, got %d", overflow)
+	}
+	if _, exists := second.values["c"]; exists || second.values["a"] != 1.0 || second.values[OVERFLOW_FIELD] != 2.0 {
+		t.Errorf("unexpected values %v", second.values)
+	}
+}
+
+func TestWithTimeoutNotice(t *testing.T) {
+	frame := withTimeoutNotice(newFrame("test", nil), nil)
+	if frame.Meta != nil {
+		t.Error("expected no notice without timeout")
+	}
+
+	err := fmt.Errorf("%w after 10s", kafka_client.ErrReadTimeout)
+	frame = withTimeoutNotice(newFrame("test", nil), err)
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 {
+		t.Fatal("expected a notice of the timeout")
+	}
+}
+
+func TestSetOffsetLinks(t *testing.T) {
+	rows := []frameRow{{values: map[string]interface{}{"value": 1.0}}}
+
+	frame := newFrame("test", rows)
+	setOffsetLinks(frame, "uid", "a&b", "")
+	if url := frame.Fields[1].Config.Links[0].URL; !strings.Contains(url, "topic=a%26b&") {
+		t.Errorf("expected the topic to be escaped, got %s", url)
+	}
+
+	frame = newFrame("test", rows)
+	setOffsetLinks(frame, "uid", "^orders-.*", "")
+	if url := frame.Fields[1].Config.Links[0].URL; !strings.Contains(url, "topic=${__data.fields.__topic:percentencode}&") {
+		t.Errorf("expected the topic of a pattern to be read from its field, got %s", url)
+	}
+	if url := frame.Fields[1].Config.Links[0].URL; strings.Contains(url, "bootstrapServers") {
+		t.Errorf("expected the links to preview the servers of the data source, got %s", url)
+	}
+
+	frame = newFrame("test", rows)
+	setOffsetLinks(frame, "uid", "orders", "a:9092,b:9092")
+	if url := frame.Fields[1].Config.Links[0].URL; !strings.HasSuffix(url, "&bootstrapServers=a%3A9092%2Cb%3A9092") {
+		t.Errorf("expected the links to preview the servers of the query, got %s", url)
+	}
+}
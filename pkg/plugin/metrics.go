@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Metrics of the streams, exported by the metrics endpoint of the plugin
+// through CollectMetrics.
+var (
+	activeStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "active_streams",
+		Help:      "Number of streams running.",
+	})
+	consumedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "consumed_messages_total",
+		Help:      "Number of messages consumed by the streams.",
+	})
+	undecodedMessages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "decode_errors_total",
+		Help:      "Number of messages the streams failed to decode.",
+	})
+	streamReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "grafana_kafka_datasource",
+		Name:      "reconnects_total",
+		Help:      "Number of reconnections of the streams to the brokers.",
+	})
+)
+
+// MetricsCollectors returns the collectors of the metrics of the plugin.
+func MetricsCollectors() []prometheus.Collector {
+	collectors := []prometheus.Collector{activeStreams, consumedMessages, undecodedMessages, streamReconnects}
+	collectors = append(collectors, kafka_client.PoolCollectors()...)
+
+	return append(collectors, kafka_client.StatsCollectors()...)
+}
+
+// metricsRegistry gathers the metrics of the plugin served by CollectMetrics.
+var metricsRegistry = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(MetricsCollectors()...)
+
+	return registry
+}
+
+// CollectMetrics serves the metrics of the plugin in the Prometheus text
+// format.
+func (d *KafkaDatasource) CollectMetrics(_ context.Context) (*backend.CollectMetricsResult, error) {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return nil, err
+		}
+	}
+
+	return &backend.CollectMetricsResult{PrometheusMetrics: buf.Bytes()}, nil
+}
@@ -1,12 +1,20 @@
 package plugin
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
+	"hash/fnv"
+	"path"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
@@ -21,6 +29,7 @@ var (
 	_ backend.QueryDataHandler      = (*KafkaDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*KafkaDatasource)(nil)
 	_ backend.StreamHandler         = (*KafkaDatasource)(nil)
+	_ backend.CallResourceHandler   = (*KafkaDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*KafkaDatasource)(nil)
 )
 
@@ -31,9 +40,19 @@ func NewKafkaInstance(s backend.DataSourceInstanceSettings) (instancemgmt.Instan
 		return nil, err
 	}
 
-	kafka_client := kafka_client.NewKafkaClient(*settings)
+	d := &KafkaDatasource{
+		settings: *settings,
+		metadata: kafka_client.NewMetadataCache(time.Duration(settings.MetadataCacheTtlMs) * time.Millisecond),
+		pool:     kafka_client.NewConsumerPool(),
+		stats:    kafka_client.NewStatsRecorder(),
+	}
+	if settings.SchemaRegistryUrl != "" {
+		d.registry = kafka_client.NewSchemaRegistry(settings.SchemaRegistryUrl,
+			settings.SchemaRegistryUsername, settings.SchemaRegistryPassword)
+	}
+	registerDatasource(d)
 
-	return &KafkaDatasource{kafka_client}, nil
+	return d, nil
 }
 
 func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*kafka_client.Options, error) {
@@ -46,16 +65,170 @@ func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*kafka_client.
 	if sasl_password, exists := s.DecryptedSecureJSONData["saslPassword"]; exists {
 		settings.SaslPassword = sasl_password
 	}
+	if api_secret, exists := s.DecryptedSecureJSONData["apiSecret"]; exists {
+		settings.APISecret = api_secret
+	}
+	if tls_ca_cert, exists := s.DecryptedSecureJSONData["tlsCaCert"]; exists {
+		settings.TlsCaCert = tls_ca_cert
+	}
+	if registry_password, exists := s.DecryptedSecureJSONData["schemaRegistryPassword"]; exists {
+		settings.SchemaRegistryPassword = registry_password
+	}
+
+	settings.ApplyDefaults()
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
 
 	return settings, nil
 }
 
+// A frame is sent once its first message waited for STREAM_DEBOUNCE, which
+// coalesces the messages of a burst without delaying the lone ones.
+const STREAM_DEBOUNCE = 50 * time.Millisecond
+
+// Delay before recreating the consumer of a stream which lost the brokers.
+const RECONNECT_INTERVAL = time.Second
+
+// KafkaDatasource creates a client from the immutable settings for every
+// operation. The metadata operations, like health checks and topic listings,
+// lease a consumer from the pool, which closes it once the last of them
+// released it, while the queries and streams reading messages each own a
+// dedicated consumer.
 type KafkaDatasource struct {
-	client kafka_client.KafkaClient
+	settings kafka_client.Options
+	metadata *kafka_client.MetadataCache
+	registry *kafka_client.SchemaRegistry
+	pool     *kafka_client.ConsumerPool
+	stats    *kafka_client.StatsRecorder
+
+	// Every running stream owns a dedicated consumer, tracked by channel path
+	// so that the streams can be cancelled when the datasource is disposed.
+	streamsMu sync.Mutex
+	streams   map[string]activeStream
+	disposed  bool
+
+	// Last error connecting to the brokers, shown by the diagnostics.
+	errorMu   sync.Mutex
+	lastError *connectionError
+}
+
+type activeStream struct {
+	client *kafka_client.KafkaClient
+	cancel context.CancelFunc
+	// Set to 1 while the stream is paused, read without the lock.
+	paused *int32
+	// Schemas of the frames sent, whose last one the joining panels get.
+	schemas *schemaTracker
+}
+
+func (d *KafkaDatasource) newClient() kafka_client.KafkaClient {
+	client := kafka_client.NewKafkaClient(d.settings)
+	client.MetadataCache = d.metadata
+	client.SchemaRegistry = d.registry
+	client.Pool = d.pool
+	client.Stats = d.stats
+	return client
+}
+
+// queryClient returns a client of the bootstrap servers of the query when it
+// overrides them, and of the datasource otherwise.
+func (d *KafkaDatasource) queryClient(qm queryModel) (kafka_client.KafkaClient, error) {
+	client := d.newClient()
+	if qm.BootstrapServers == "" {
+		return client, nil
+	}
+	servers, err := d.settings.QueryBootstrapServers(qm.BootstrapServers)
+	if err != nil {
+		return client, err
+	}
+	client.BootstrapServers = servers
+	// The cached metadata is the one of the datasource cluster.
+	client.MetadataCache = nil
+
+	return client, nil
 }
 
+// addStream tracks a stream, unless the datasource already runs as many
+// streams as it allows.
+func (d *KafkaDatasource) addStream(path string, client *kafka_client.KafkaClient, cancel context.CancelFunc) error {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+
+	if d.streams == nil {
+		d.streams = make(map[string]activeStream)
+	}
+	if limit := int(d.settings.MaxConcurrentStreams); limit > 0 && len(d.streams) >= limit {
+		return fmt.Errorf("too many concurrent streams, the data source allows %d", limit)
+	}
+	if err := startStream(); err != nil {
+		return err
+	}
+	// A stream starting while the datasource is disposed stops right away.
+	if d.disposed {
+		cancel()
+	}
+	d.streams[path] = activeStream{client: client, cancel: cancel, paused: new(int32), schemas: &schemaTracker{}}
+	activeStreams.Inc()
+	log.DefaultLogger.Info("Stream started", "path", path, "activeStreams", len(d.streams))
+
+	return nil
+}
+
+func (d *KafkaDatasource) removeStream(path string) {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+
+	if stream, exists := d.streams[path]; exists {
+		stream.client.Dispose()
+		delete(d.streams, path)
+		activeStreams.Dec()
+		runningStreams.Done()
+	}
+	log.DefaultLogger.Info("Stream stopped", "path", path, "activeStreams", len(d.streams))
+}
+
+// pausedFlag returns the flag of the stream set while it is paused, nil when
+// the stream isn't running.
+func (d *KafkaDatasource) pausedFlag(path string) *int32 {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+
+	stream, exists := d.streams[path]
+	if !exists {
+		return nil
+	}
+	return stream.paused
+}
+
+// streamSchemas returns the schemas of the frames sent by the stream, nil
+// when it isn't running.
+func (d *KafkaDatasource) streamSchemas(path string) *schemaTracker {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+
+	stream, exists := d.streams[path]
+	if !exists {
+		return nil
+	}
+	return stream.schemas
+}
+
+// Dispose is called when the settings of the datasource change. It cancels
+// the running streams, which dispose their consumers as they return, so that
+// none keeps consuming from the previous cluster.
 func (d *KafkaDatasource) Dispose() {
-	// Clean up datasource instance resources.
+	unregisterDatasource(d)
+	d.pool.Close()
+
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+
+	d.disposed = true
+	for path, stream := range d.streams {
+		log.DefaultLogger.Info("Cancelling stream", "path", path)
+		stream.cancel()
+	}
 }
 
 func (d *KafkaDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
@@ -73,38 +246,480 @@ func (d *KafkaDatasource) QueryData(ctx context.Context, req *backend.QueryDataR
 }
 
 type queryModel struct {
-	Topic           string `json:"topicName"`
-	Partition       int32  `json:"partition"`
-	WithStreaming   bool   `json:"withStreaming"`
-	AutoOffsetReset string `json:"autoOffsetReset"`
-	TimestampMode   string `json:"timestampMode"`
+	Topic                string   `json:"topicName"`
+	Partition            int32    `json:"partition"`
+	WithStreaming        bool     `json:"withStreaming"`
+	AutoOffsetReset      string   `json:"autoOffsetReset"`
+	TimestampMode        string   `json:"timestampMode"`
+	PrefetchLast         int64    `json:"prefetchLast"`
+	IncludeFields        []string `json:"includeFields,omitempty"`
+	ExcludeFields        []string `json:"excludeFields,omitempty"`
+	Format               string   `json:"format,omitempty"`
+	CSVHeader            string   `json:"csvHeader,omitempty"`
+	CSVDelimiter         string   `json:"csvDelimiter,omitempty"`
+	SampleRate           int64    `json:"sampleRate,omitempty"`
+	MaxMessagesPerSecond int64    `json:"maxMessagesPerSecond,omitempty"`
+	Aggregation          string   `json:"aggregation,omitempty"`
+	AggregationWindow    string   `json:"aggregationWindow,omitempty"`
+	FromOffset           *int64   `json:"fromOffset,omitempty"`
+	ToOffset             *int64   `json:"toOffset,omitempty"`
+	Mode                 string   `json:"mode,omitempty"`
+	// Fields holding a time series as parallel arrays, expanded into a row
+	// per point.
+	SeriesTimeField  string `json:"seriesTimeField,omitempty"`
+	SeriesValueField string `json:"seriesValueField,omitempty"`
+	// Maximum number of distinct fields, DEFAULT_MAX_FIELDS when not set.
+	MaxFields int `json:"maxFields,omitempty"`
+	// Tombstones are shown as a row with a __tombstone field unless skipped.
+	SkipTombstones bool `json:"skipTombstones,omitempty"`
+	// Display names of the fields, by field name.
+	FieldAliases map[string]string `json:"fieldAliases,omitempty"`
+	// Bounds of the numeric fields, by field name. The values out of bounds
+	// are left null.
+	ClampMin map[string]float64 `json:"clampMin,omitempty"`
+	ClampMax map[string]float64 `json:"clampMax,omitempty"`
+	// Field holding the time of the rows instead of the message timestamp,
+	// along with its format, one of TIME_FIELD_FORMATS.
+	TimeField       string `json:"timeField,omitempty"`
+	TimeFieldFormat string `json:"timeFieldFormat,omitempty"`
+	// Fields holding ISO 8601 timestamps, shown as times rather than strings.
+	TimestampFields []string `json:"timestampFields,omitempty"`
+	// Fields naming the series of the value field, pivoted into a field
+	// named after the value of the name field.
+	NameField  string `json:"nameField,omitempty"`
+	ValueField string `json:"valueField,omitempty"`
+	// Field of the JSON messages holding a bare value, like a number,
+	// kafka_client.DEFAULT_SCALAR_FIELD when not set.
+	ScalarFieldName string `json:"scalarFieldName,omitempty"`
+	// Decodes the keys of the messages instead of their values.
+	FromKey bool `json:"fromKey,omitempty"`
+	// Drops the schema registry prefix of the messages before decoding them.
+	StripSchemaPrefix bool `json:"stripSchemaPrefix,omitempty"`
+	// Adds the partition and offset of the messages, which the values of the
+	// stream frames link to.
+	IncludeMetadata bool `json:"includeMetadata,omitempty"`
+	// Adds the raw value of the messages next to the decoded fields.
+	IncludeRaw bool `json:"includeRaw,omitempty"`
+	// Numbers the messages of the streams, across the partitions, so that
+	// the messages dropped on the way show as gaps.
+	IncludeSeq bool `json:"includeSeq,omitempty"`
+	// String values longer than that many characters are truncated, 0
+	// keeps them whole.
+	MaxStringLength int `json:"maxStringLength,omitempty"`
+	// Reads every partition with a consumer of its own when streaming all
+	// the partitions of a topic.
+	ParallelPartitions bool `json:"parallelPartitions,omitempty"`
+	// Grouping of the rows of the topics matched by a pattern, one of
+	// FRAME_MODES.
+	FrameMode string `json:"frameMode,omitempty"`
+	// Name of the frames, the topic and partition when not set.
+	FrameName string `json:"frameName,omitempty"`
+	// Order of the rows of the stream frames, one of SORT_BY.
+	SortBy string `json:"sortBy,omitempty"`
+	// Streams start from the messages that recent, like 5m, instead of the
+	// auto offset reset.
+	StartRelative string `json:"startRelative,omitempty"`
+	// Streams start from the beginning of the time range of the panel, which
+	// the query passes on in epoch milliseconds.
+	UseTimeRange  bool  `json:"useTimeRange,omitempty"`
+	TimeRangeFrom int64 `json:"timeRangeFrom,omitempty"`
+	TimeRangeTo   int64 `json:"timeRangeTo,omitempty"`
+	// Drops the rows timed before the start of the stream, or after the end
+	// of the time range it starts at, which the offsets found by time let
+	// through.
+	StrictTimeRange bool `json:"strictTimeRange,omitempty"`
+	// Servers of another cluster to read from, one of the servers allowed by
+	// the datasource.
+	BootstrapServers string `json:"bootstrapServers,omitempty"`
+	// Streams fail after that many messages in a row fail to decode, 0
+	// skips them all.
+	MaxConsecutiveDecodeErrors int64 `json:"maxConsecutiveDecodeErrors,omitempty"`
+	// Streaming queries return a frame without the initial zero values,
+	// holding the channel of the stream only.
+	SuppressInitialFrame bool `json:"suppressInitialFrame,omitempty"`
+	// Only the messages whose key starts with the filter are read, or
+	// matches it when it starts with ^, like the topic patterns.
+	KeyFilter string `json:"keyFilter,omitempty"`
+	// Shows every record as an event, a row of all its fields, for the table
+	// and logs panels: the arrays are kept as JSON text instead of being left
+	// out, and the options reshaping the records into series are refused.
+	EventMode bool `json:"eventMode,omitempty"`
+}
+
+// Queries read the messages of the topic by default, while QUERY_MODE_OFFSETS
+// queries return the watermark offsets of its partitions and
+// QUERY_MODE_SNAPSHOT queries read the messages of the partitions once, up to
+// their end, without streaming. QUERY_MODE_HISTORY streams read the
+// partitions from their beginning, then keep tailing them.
+const QUERY_MODE_MESSAGES = "messages"
+const QUERY_MODE_OFFSETS = "offsets"
+const QUERY_MODE_SNAPSHOT = "snapshot"
+const QUERY_MODE_HISTORY = "history"
+
+var QUERY_MODES = []string{QUERY_MODE_MESSAGES, QUERY_MODE_OFFSETS, QUERY_MODE_SNAPSHOT, QUERY_MODE_HISTORY}
+
+// csvDelimiter returns the delimiter of the CSV format, accepting \t for tabs.
+func (qm queryModel) csvDelimiter() (rune, error) {
+	delimiter := qm.CSVDelimiter
+	if delimiter == "" {
+		return ',', nil
+	}
+	if delimiter == "\\t" {
+		delimiter = "\t"
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return 0, fmt.Errorf("invalid CSV delimiter %q, expected a single character", qm.CSVDelimiter)
+	}
+	r, _ := utf8.DecodeRuneInString(delimiter)
+
+	return r, nil
+}
+
+func (qm queryModel) aggregationWindow() (time.Duration, error) {
+	if qm.AggregationWindow == "" {
+		return DEFAULT_AGGREGATION_WINDOW, nil
+	}
+	window, err := time.ParseDuration(qm.AggregationWindow)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid aggregation window %q, expected a positive duration like 10s", qm.AggregationWindow)
+	}
+
+	return window, nil
+}
+
+// frameName returns the name of the frames of the query, the topic and the
+// partition unless the query names them.
+func (qm queryModel) frameName() string {
+	switch {
+	case qm.FrameName != "":
+		return qm.FrameName
+	case qm.Partition == kafka_client.ALL_PARTITIONS:
+		return qm.Topic
+	default:
+		return fmt.Sprintf("%s/%d", qm.Topic, qm.Partition)
+	}
+}
+
+// startRelative returns how far back the streams start, 0 when they start at
+// the auto offset reset.
+func (qm queryModel) startRelative() (time.Duration, error) {
+	if qm.StartRelative == "" {
+		return 0, nil
+	}
+	start, err := time.ParseDuration(qm.StartRelative)
+	if err != nil || start <= 0 {
+		return 0, fmt.Errorf("invalid relative start %q, expected a positive duration like 5m", qm.StartRelative)
+	}
+
+	return start, nil
+}
+
+// startTime returns the time the streams start from, the zero time when they
+// start at the auto offset reset.
+func (qm queryModel) startTime(now time.Time) time.Time {
+	if qm.UseTimeRange && qm.TimeRangeFrom > 0 {
+		return time.Unix(0, qm.TimeRangeFrom*int64(time.Millisecond))
+	}
+	if start, _ := qm.startRelative(); start > 0 {
+		return now.Add(-start)
+	}
+
+	return time.Time{}
+}
+
+// keyMatcher returns the test of the key filter, nil without filter.
+func (qm queryModel) keyMatcher() (func(key []byte) bool, error) {
+	if qm.KeyFilter == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(qm.KeyFilter, "^") {
+		prefix := []byte(qm.KeyFilter)
+		return func(key []byte) bool { return bytes.HasPrefix(key, prefix) }, nil
+	}
+	pattern, err := regexp.Compile(qm.KeyFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key filter %q: %w", qm.KeyFilter, err)
+	}
+
+	return pattern.Match, nil
+}
+
+// A time range ending less than TIME_RANGE_NOW_MARGIN before the stream
+// starts ends now, like the relative time ranges of the panels.
+const TIME_RANGE_NOW_MARGIN = time.Minute
+
+// endTime returns the end of the time range the streams start at when it is
+// past, the zero time when they don't or when it ends now: the messages
+// coming in are then all in the time range.
+func (qm queryModel) endTime(now time.Time) time.Time {
+	if !qm.UseTimeRange || qm.TimeRangeTo <= 0 {
+		return time.Time{}
+	}
+	end := time.Unix(0, qm.TimeRangeTo*int64(time.Millisecond))
+	if !end.Before(now.Add(-TIME_RANGE_NOW_MARGIN)) {
+		return time.Time{}
+	}
+	return end
+}
+
+// inTimeRange tells whether a row time is within the bounds, which are open
+// when zero.
+func inTimeRange(t time.Time, from time.Time, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+func (qm queryModel) decodeOptions() (kafka_client.DecodeOptions, error) {
+	options := kafka_client.DecodeOptions{
+		Format:            qm.Format,
+		FromKey:           qm.FromKey,
+		StripSchemaPrefix: qm.StripSchemaPrefix,
+		ScalarField:       qm.ScalarFieldName,
+	}
+
+	if qm.Format == kafka_client.FORMAT_CSV {
+		delimiter, err := qm.csvDelimiter()
+		if err != nil {
+			return options, err
+		}
+		options.CSVDelimiter = delimiter
+		if qm.CSVHeader != "" {
+			options.CSVHeader, err = kafka_client.SplitCSV(qm.CSVHeader, delimiter)
+			if err != nil {
+				return options, fmt.Errorf("invalid CSV header: %w", err)
+			}
+		}
+	}
+
+	return options, nil
+}
+
+// applyQueryDefaults falls back to the datasource topic and partition when
+// the query doesn't set a topic, and to the datasource auto offset reset when
+// the query doesn't override it.
+func (d *KafkaDatasource) applyQueryDefaults(qm *queryModel) {
+	if qm.Topic == "" {
+		qm.Topic = d.settings.DefaultTopic
+		qm.Partition = d.settings.DefaultPartition
+	}
+	if qm.AutoOffsetReset == "" {
+		qm.AutoOffsetReset = d.settings.AutoOffsetReset
+	}
+}
+
+// queryError is an invalid field of a query, named after its JSON key.
+type queryError struct {
+	Field   string
+	Message string
+}
+
+func (e queryError) Error() string {
+	return e.Message
+}
+
+// parseQuery decodes a query, naming the field of the JSON type errors.
+func parseQuery(raw []byte) (queryModel, error) {
+	var qm queryModel
+	if err := json.Unmarshal(raw, &qm); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return qm, queryError{
+				Field:   typeErr.Field,
+				Message: fmt.Sprintf("%s must be of type %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value),
+			}
+		}
+		return qm, err
+	}
+
+	return qm, nil
+}
+
+func (qm queryModel) validate() error {
+	if qm.Topic == "" {
+		return queryError{Field: "topicName", Message: "missing topicName"}
+	}
+	if qm.Partition < kafka_client.ALL_PARTITIONS {
+		return queryError{
+			Field:   "partition",
+			Message: fmt.Sprintf("invalid partition %d, expected -1 for all the partitions or a partition number", qm.Partition),
+		}
+	}
+	// The patterns subscribe to the topics they match, whose partitions
+	// differ.
+	if strings.HasPrefix(qm.Topic, "^") && qm.Partition != kafka_client.ALL_PARTITIONS {
+		return queryError{Field: "partition", Message: "topic patterns read all the partitions, expected -1"}
+	}
+	if qm.Mode != "" && !contains(QUERY_MODES, qm.Mode) {
+		return queryError{
+			Field:   "mode",
+			Message: fmt.Sprintf("invalid mode %q, expected one of %s", qm.Mode, strings.Join(QUERY_MODES, ", ")),
+		}
+	}
+	if qm.AutoOffsetReset != "" && !contains(kafka_client.AUTO_OFFSET_RESETS, qm.AutoOffsetReset) {
+		return queryError{
+			Field: "autoOffsetReset",
+			Message: fmt.Sprintf("invalid auto offset reset %q, expected one of %s",
+				qm.AutoOffsetReset, strings.Join(kafka_client.AUTO_OFFSET_RESETS, ", ")),
+		}
+	}
+	// The subscriptions of all the partitions have no committed offsets to
+	// resume from.
+	if qm.AutoOffsetReset == kafka_client.AUTO_OFFSET_RESET_ERROR && qm.Partition == kafka_client.ALL_PARTITIONS {
+		return queryError{
+			Field:   "autoOffsetReset",
+			Message: fmt.Sprintf("auto offset reset %q requires a single partition", kafka_client.AUTO_OFFSET_RESET_ERROR),
+		}
+	}
+	if qm.Format != "" && !contains(kafka_client.FORMATS, qm.Format) {
+		return queryError{
+			Field:   "format",
+			Message: fmt.Sprintf("invalid format %q, expected one of %s", qm.Format, strings.Join(kafka_client.FORMATS, ", ")),
+		}
+	}
+	if _, err := qm.csvDelimiter(); err != nil {
+		return queryError{Field: "csvDelimiter", Message: err.Error()}
+	}
+	if qm.TimeFieldFormat != "" && !contains(TIME_FIELD_FORMATS, qm.TimeFieldFormat) {
+		return queryError{
+			Field: "timeFieldFormat",
+			Message: fmt.Sprintf("invalid time field format %q, expected one of %s",
+				qm.TimeFieldFormat, strings.Join(TIME_FIELD_FORMATS, ", ")),
+		}
+	}
+	if qm.FrameMode != "" && !contains(FRAME_MODES, qm.FrameMode) {
+		return queryError{
+			Field:   "frameMode",
+			Message: fmt.Sprintf("invalid frame mode %q, expected one of %s", qm.FrameMode, strings.Join(FRAME_MODES, ", ")),
+		}
+	}
+	if qm.SortBy != "" && !contains(SORT_BY, qm.SortBy) {
+		return queryError{
+			Field:   "sortBy",
+			Message: fmt.Sprintf("invalid sort %q, expected one of %s", qm.SortBy, strings.Join(SORT_BY, ", ")),
+		}
+	}
+	if qm.MaxFields < 0 {
+		return queryError{Field: "maxFields", Message: "maximum fields must not be negative"}
+	}
+	if qm.SampleRate < 0 {
+		return queryError{Field: "sampleRate", Message: "sample rate must not be negative"}
+	}
+	if qm.MaxMessagesPerSecond < 0 {
+		return queryError{Field: "maxMessagesPerSecond", Message: "maximum messages per second must not be negative"}
+	}
+	if qm.ParallelPartitions && strings.HasPrefix(qm.Topic, "^") {
+		return queryError{Field: "parallelPartitions", Message: "parallel partitions don't support topic patterns"}
+	}
+	if qm.MaxStringLength < 0 {
+		return queryError{Field: "maxStringLength", Message: "maximum string length must not be negative"}
+	}
+	if qm.MaxConsecutiveDecodeErrors < 0 {
+		return queryError{Field: "maxConsecutiveDecodeErrors", Message: "maximum consecutive decode errors must not be negative"}
+	}
+	if qm.EventMode && (qm.Aggregation != "" || qm.SeriesValueField != "" || qm.ValueField != "") {
+		return queryError{
+			Field:   "eventMode",
+			Message: "event mode shows every record as a row, it doesn't support aggregations, series or pivots",
+		}
+	}
+	if qm.Aggregation != "" && !contains(AGGREGATIONS, qm.Aggregation) {
+		return queryError{
+			Field:   "aggregation",
+			Message: fmt.Sprintf("invalid aggregation %q, expected one of %s", qm.Aggregation, strings.Join(AGGREGATIONS, ", ")),
+		}
+	}
+	if _, err := qm.aggregationWindow(); err != nil {
+		return queryError{Field: "aggregationWindow", Message: err.Error()}
+	}
+	if _, err := qm.startRelative(); err != nil {
+		return queryError{Field: "startRelative", Message: err.Error()}
+	}
+	if _, err := qm.keyMatcher(); err != nil {
+		return queryError{Field: "keyFilter", Message: err.Error()}
+	}
+	if qm.FromOffset == nil && qm.ToOffset != nil {
+		return queryError{Field: "fromOffset", Message: "both fromOffset and toOffset must be set to replay a range of offsets"}
+	}
+	if qm.FromOffset != nil && qm.ToOffset == nil {
+		return queryError{Field: "toOffset", Message: "both fromOffset and toOffset must be set to replay a range of offsets"}
+	}
+	if qm.FromOffset != nil {
+		if qm.Partition < 0 {
+			return queryError{Field: "partition", Message: "a partition must be selected to replay a range of offsets"}
+		}
+		if *qm.FromOffset < 0 || *qm.FromOffset > *qm.ToOffset {
+			return queryError{
+				Field:   "fromOffset",
+				Message: fmt.Sprintf("invalid offset range %d to %d", *qm.FromOffset, *qm.ToOffset),
+			}
+		}
+	}
+	for _, field := range []struct {
+		name     string
+		patterns []string
+	}{{"includeFields", qm.IncludeFields}, {"excludeFields", qm.ExcludeFields}} {
+		for _, pattern := range field.patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return queryError{Field: field.name, Message: fmt.Sprintf("invalid field pattern %q: %s", pattern, err)}
+			}
+		}
+	}
+
+	return nil
 }
 
 func (d *KafkaDatasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
 	response := backend.DataResponse{}
-	var qm queryModel
-	response.Error = json.Unmarshal(query.JSON, &qm)
+	qm, err := parseQuery(query.JSON)
+	if err != nil {
+		response.Error = fmt.Errorf("invalid Kafka query: %w", err)
+		return response
+	}
 
+	d.applyQueryDefaults(&qm)
+	if err := qm.validate(); err != nil {
+		response.Error = fmt.Errorf("invalid Kafka query: %w", err)
+		return response
+	}
+
+	if _, err := d.queryClient(qm); err != nil {
+		response.Error = err
+		return response
+	}
+
+	if qm.Mode == QUERY_MODE_OFFSETS {
+		return d.queryOffsets(qm)
+	}
+	response.Error = d.checkInternalTopic(qm)
 	if response.Error != nil {
 		return response
 	}
+	// Without streaming, the history is all there is to read.
+	if qm.Mode == QUERY_MODE_SNAPSHOT || (qm.Mode == QUERY_MODE_HISTORY && !qm.WithStreaming) {
+		return withInternalTopicNotice(d.querySnapshot(qm), qm)
+	}
+	if qm.FromOffset != nil && qm.ToOffset != nil {
+		return withInternalTopicNotice(d.queryRange(qm), qm)
+	}
 
-	frame := data.NewFrame("response")
+	frame := data.NewFrame(qm.frameName())
 
-	frame.Fields = append(frame.Fields,
-		data.NewField("time", nil, []time.Time{query.TimeRange.From, query.TimeRange.To}),
-		data.NewField("values", nil, []int64{0, 0}),
-	)
+	// Without the zero values, the frame only points the panel to the stream.
+	if !qm.WithStreaming || !qm.SuppressInitialFrame {
+		frame.Fields = append(frame.Fields,
+			data.NewField("time", nil, []time.Time{query.TimeRange.From, query.TimeRange.To}),
+			data.NewField("values", nil, []int64{0, 0}),
+		)
+	}
 
-	topic := qm.Topic
-	partition := qm.Partition
-	autoOffsetReset := qm.AutoOffsetReset
-	timestampMode := qm.TimestampMode
 	if qm.WithStreaming {
+		if qm.UseTimeRange {
+			qm.TimeRangeFrom = query.TimeRange.From.UnixNano() / int64(time.Millisecond)
+			qm.TimeRangeTo = query.TimeRange.To.UnixNano() / int64(time.Millisecond)
+		}
 		channel := live.Channel{
 			Scope:     live.ScopeDatasource,
 			Namespace: pCtx.DataSourceInstanceSettings.UID,
-			Path:      fmt.Sprintf("%v_%d_%v_%v", topic, partition, autoOffsetReset, timestampMode),
+			Path:      streamPath(qm),
 		}
 		frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
 	}
@@ -114,17 +729,211 @@ func (d *KafkaDatasource) query(_ context.Context, pCtx backend.PluginContext, q
 	return response
 }
 
+// checkInternalTopic refuses to read the messages of the internal topics,
+// like __consumer_offsets, unless the datasource allows it.
+func (d *KafkaDatasource) checkInternalTopic(qm queryModel) error {
+	if !kafka_client.IsInternalTopic(qm.Topic) || d.settings.AllowInternalTopics {
+		return nil
+	}
+	return fmt.Errorf("topic %s is internal, enable Allow Internal Topics in the data source settings to read it", qm.Topic)
+}
+
+// internalTopicWarning tells when the messages of an internal topic are read
+// with a format which cannot decode their binary records.
+func internalTopicWarning(qm queryModel) string {
+	if !kafka_client.IsInternalTopic(qm.Topic) || qm.Format == kafka_client.FORMAT_BASE64 || qm.Format == kafka_client.FORMAT_HEX {
+		return ""
+	}
+	return fmt.Sprintf("Topic %s is internal and holds binary records, read it with the base64 or hex format.", qm.Topic)
+}
+
+func withInternalTopicNotice(response backend.DataResponse, qm queryModel) backend.DataResponse {
+	warning := internalTopicWarning(qm)
+	if warning == "" {
+		return response
+	}
+	for _, frame := range response.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: warning})
+	}
+
+	return response
+}
+
+// withTimeoutNotice warns that the frame only holds the messages read before
+// its bounded read timed out, when it did.
+func withTimeoutNotice(frame *data.Frame, err error) *data.Frame {
+	if !errors.Is(err, kafka_client.ErrReadTimeout) {
+		return frame
+	}
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+	frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Only the messages read in time are shown: %s", err),
+	})
+
+	return frame
+}
+
+// queryRange replays a range of offsets of a partition into a frame.
+func (d *KafkaDatasource) queryRange(qm queryModel) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	client, err := d.queryClient(qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	client.Decode, response.Error = qm.decodeOptions()
+	if response.Error != nil {
+		return response
+	}
+
+	messages, err := client.ReadRange(qm.Topic, qm.Partition, *qm.FromOffset, *qm.ToOffset)
+	if kafka_client.IsTopicAuthorizationError(err) {
+		response.Error = fmt.Errorf("not authorized to read topic %s, check its ACLs: %w", qm.Topic, err)
+		return response
+	}
+	if err != nil && !errors.Is(err, kafka_client.ErrReadTimeout) {
+		response.Error = fmt.Errorf("error reading offsets %d to %d: %w", *qm.FromOffset, *qm.ToOffset, err)
+		return response
+	}
+
+	response.Frames = append(response.Frames, withTimeoutNotice(messagesFrame(messages, qm), err))
+
+	return response
+}
+
+// querySnapshot reads the messages of the partition, or of every partition,
+// up to the high watermarks at the time of the query.
+func (d *KafkaDatasource) querySnapshot(qm queryModel) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	client, err := d.queryClient(qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	client.Decode, response.Error = qm.decodeOptions()
+	if response.Error != nil {
+		return response
+	}
+
+	messages, err := client.ReadSnapshot(qm.Topic, qm.Partition)
+	if kafka_client.IsTopicAuthorizationError(err) {
+		response.Error = fmt.Errorf("not authorized to read topic %s, check its ACLs: %w", qm.Topic, err)
+		return response
+	}
+	if err != nil && !errors.Is(err, kafka_client.ErrReadTimeout) {
+		response.Error = fmt.Errorf("error reading topic %s: %w", qm.Topic, err)
+		return response
+	}
+	response.Frames = append(response.Frames, withTimeoutNotice(messagesFrame(messages, qm), err))
+
+	return response
+}
+
+// messagesFrame builds the frame of the messages read by a query, timed by
+// their timestamp.
+func messagesFrame(messages []*kafka_client.ConsumedMessage, qm queryModel) *data.Frame {
+	var rows []frameRow
+	fields := newFieldLimiter(qm.MaxFields)
+	keyMatches, _ := qm.keyMatcher()
+	for _, msg := range messages {
+		if keyMatches != nil && !keyMatches(msg.Key) {
+			continue
+		}
+		if msg.DecodeError != nil {
+			log.DefaultLogger.Warn("Error decoding message", "topic", qm.Topic, "offset", msg.Offset, "error", msg.DecodeError)
+			continue
+		}
+		if msg.Tombstone && qm.SkipTombstones {
+			continue
+		}
+		for _, row := range messageRows(msg, msg.Timestamp, qm) {
+			fields.limit(row)
+			rows = append(rows, row)
+		}
+	}
+
+	return newFrame(qm.frameName(), rows)
+}
+
+// queryOffsets returns the low and high watermark offsets of every partition
+// of the topic.
+func (d *KafkaDatasource) queryOffsets(qm queryModel) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	client, err := d.queryClient(qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	offsets, err := client.WatermarkOffsets(qm.Topic)
+	if kafka_client.IsTopicAuthorizationError(err) {
+		response.Error = fmt.Errorf("not authorized to read topic %s, check its ACLs: %w", qm.Topic, err)
+		return response
+	}
+	if err != nil {
+		response.Error = fmt.Errorf("error querying the offsets of topic %s: %w", qm.Topic, err)
+		return response
+	}
+
+	partitions := make([]int32, len(offsets))
+	lows := make([]int64, len(offsets))
+	highs := make([]int64, len(offsets))
+	for i, offset := range offsets {
+		partitions[i] = offset.Partition
+		lows[i] = offset.Low
+		highs[i] = offset.High
+	}
+	response.Frames = append(response.Frames, data.NewFrame("offsets",
+		data.NewField("partition", nil, partitions),
+		data.NewField("low", nil, lows),
+		data.NewField("high", nil, highs),
+	))
+
+	return response
+}
+
 func (d *KafkaDatasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	log.DefaultLogger.Info("CheckHealth called", "request", req)
 
 	var status = backend.HealthStatusOk
 	var message = "Data source is working"
 
-	err := d.client.HealthCheck()
+	client := d.newClient()
+	err := client.HealthCheck(d.settings.DefaultTopic)
 
 	if err != nil {
 		status = backend.HealthStatusError
-		message = "Cannot connect to the brokers!"
+		switch {
+		case kafka_client.IsAuthenticationError(err):
+			message = "Authentication failed — check credentials."
+		case kafka_client.IsAuthorizationError(err):
+			message = "Authorization failed — check the ACLs of the user."
+		case kafka_client.IsUnknownTopicError(err):
+			message = fmt.Sprintf("Topic %s does not exist.", d.settings.DefaultTopic)
+		default:
+			message = "Cannot connect to the brokers!"
+		}
+		log.DefaultLogger.Error("Health check failed", "error", err)
+		d.recordError(err)
+	} else if d.settings.DeepHealthCheck {
+		if err := client.RoundTrip(); err != nil {
+			status = backend.HealthStatusError
+			message = fmt.Sprintf("Connected to the brokers, but the round trip through %s failed: %s",
+				kafka_client.HEALTHCHECK_TOPIC, err)
+			log.DefaultLogger.Error("Round trip health check failed", "error", err)
+		}
+	}
+
+	if status == backend.HealthStatusOk {
+		message = fmt.Sprintf("%s (plugin %s, librdkafka %s)", message, pluginVersion(), kafka_client.LibraryVersion())
 	}
 
 	return &backend.CheckHealthResult{
@@ -133,74 +942,380 @@ func (d *KafkaDatasource) CheckHealth(_ context.Context, req *backend.CheckHealt
 	}, nil
 }
 
+// streamPath encodes the query into a channel path, so that RunStream gets
+// every stream setting back without keeping any state between the calls.
+func streamPath(qm queryModel) string {
+	bytes, _ := json.Marshal(qm)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// streamId shortens the path of a stream into an id which stays the same
+// across the restarts of Grafana.
+func streamId(path string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// parseStreamPath is the inverse of streamPath.
+func parseStreamPath(path string) (queryModel, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(path)
+	if err != nil {
+		return queryModel{}, fmt.Errorf("invalid stream path %s: %w", path, err)
+	}
+	qm, err := parseQuery(bytes)
+	if err != nil {
+		return qm, fmt.Errorf("invalid stream path %s: %w", path, err)
+	}
+
+	return qm, qm.validate()
+}
+
 func (d *KafkaDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	log.DefaultLogger.Info("SubscribeStream called", "request", req)
-	// Extract the query parameters
-	var path []string = strings.Split(req.Path, "_")
-	topic := path[0]
-	partition, _ := strconv.Atoi(path[1])
-	autoOffsetReset := path[2]
-	timestampMode := path[3]
-	// Initialize Consumer and Assign the topic
-	d.client.TopicAssign(topic, int32(partition), autoOffsetReset, timestampMode)
-	status := backend.SubscribeStreamStatusPermissionDenied
-	status = backend.SubscribeStreamStatusOK
+
+	status := backend.SubscribeStreamStatusOK
+	if _, err := parseStreamPath(req.Path); err != nil {
+		log.DefaultLogger.Error("Invalid stream path", "path", req.Path, "error", err)
+		status = backend.SubscribeStreamStatusNotFound
+	}
+
+	// The panels joining a running stream get the schema of its last frame,
+	// the frames following it being sent without their schema when it didn't
+	// change.
+	initialData, err := d.streamSchemas(req.Path).initialData()
+	if err != nil {
+		log.DefaultLogger.Error("Failed to create the initial data", "path", req.Path, "error", err)
+	}
 
 	return &backend.SubscribeStreamResponse{
-		Status: status,
+		Status:      status,
+		InitialData: initialData,
 	}, nil
 }
 
+// reconnect waits for RECONNECT_INTERVAL, then recreates the consumer of the
+// stream and assigns it the topic again. The partitions resume after their
+// last message consumed, the other ones start like the stream did. A failed
+// assignment disposes of the consumer, whose next pull then reconnects again
+// rather than waiting for the messages of no partition.
+func (d *KafkaDatasource) reconnect(ctx context.Context, client *kafka_client.KafkaClient, qm queryModel) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(RECONNECT_INTERVAL):
+	}
+
+	client.Dispose()
+	if err := client.TopicAssign(ctx, qm.Topic, qm.Partition, qm.AutoOffsetReset, qm.TimestampMode, qm.PrefetchLast); err != nil {
+		client.Dispose()
+		return err
+	}
+
+	return nil
+}
+
 func (d *KafkaDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
-	log.DefaultLogger.Info("RunStream called", "request", req)
+	qm, err := parseStreamPath(req.Path)
+	if err != nil {
+		log.DefaultLogger.Error("Invalid stream path", "path", req.Path, "error", err)
+		return err
+	}
+	d.applyQueryDefaults(&qm)
+	logger := newStreamLogger(req, qm)
+	logger.Info("Starting stream")
+	if err := d.checkInternalTopic(qm); err != nil {
+		logger.Error("Refusing to stream", "error", err)
+		return err
+	}
+	if warning := internalTopicWarning(qm); warning != "" {
+		logger.Warn(warning)
+	}
+
+	// The stream is tracked before its consumer connects, so that the streams
+	// beyond the limit don't reach the brokers at all.
+	client, err := d.queryClient(qm)
+	if err != nil {
+		logger.Error("Refusing to stream", "error", err)
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := d.addStream(req.Path, &client, cancel); err != nil {
+		logger.Error("Refusing to stream", "error", err)
+		if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+			logger.Error("Error sending frame", "error", err)
+		}
+		return err
+	}
+	// Disposes the consumer of the stream.
+	defer d.removeStream(req.Path)
+
+	// Initialize a consumer dedicated to this stream and assign the topic
+	client.StreamId = streamId(req.Path)
+	client.StartTime = qm.startTime(time.Now())
+	// The strict streams of a past time range stop at its end.
+	if qm.StrictTimeRange {
+		client.EndTime = qm.endTime(time.Now())
+	}
+	client.FromBeginning = qm.Mode == QUERY_MODE_HISTORY
+	client.ParallelPartitions = qm.ParallelPartitions
+	// Set before the assignment, which copies the client into the workers of
+	// the parallel partitions.
+	client.Decode, err = qm.decodeOptions()
+	if err != nil {
+		logger.Error("Invalid decode options", "error", err)
+		return err
+	}
+	if err := client.TopicAssign(ctx, qm.Topic, qm.Partition, qm.AutoOffsetReset, qm.TimestampMode, qm.PrefetchLast); err != nil {
+		logger.Error("Error assigning topic", "error", err)
+		d.recordError(err)
+		if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+			logger.Error("Error sending frame", "error", err)
+		}
+		return err
+	}
+	// Checked by the validation of the query.
+	keyMatches, _ := qm.keyMatcher()
+
+	paused := d.pausedFlag(req.Path)
+
+	// The last frame sent, whose schema the keepalive frames repeat.
+	lastFrame, lastSent := newStartedFrame(qm.frameName(), &client, qm), time.Now()
+	schemas := d.streamSchemas(req.Path)
+	if err := sender.SendFrame(lastFrame, schemas.include(lastFrame)); err != nil {
+		logger.Error("Error sending frame", "error", err)
+	}
+	keepalive := time.Duration(d.settings.KeepaliveIntervalMs) * time.Millisecond
+
+	// Messages are sent as they arrive, the bursts being coalesced in a frame
+	var pending batch
+	sampling := sampler{rate: qm.SampleRate, maxPerSecond: qm.MaxMessagesPerSecond}
+	var aggregation *aggregator
+	if qm.Aggregation != "" {
+		window, _ := qm.aggregationWindow()
+		aggregation = &aggregator{function: qm.Aggregation, window: window}
+	}
+	var uid string
+	if req.PluginContext.DataSourceInstanceSettings != nil {
+		uid = req.PluginContext.DataSourceInstanceSettings.UID
+	}
+	fields := newFieldLimiter(qm.MaxFields)
+	overflowWarned := false
+	var reconnectAttempts int32
+	decodeErrors := decodeErrorLimit{max: qm.MaxConsecutiveDecodeErrors}
+	// Number of the last message of the stream turned into rows.
+	var seq int64
+	// Status of the frames of the history, until the end of every partition
+	// is reached.
+	phase := "streaming"
+	if client.FromBeginning {
+		phase = "history"
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.DefaultLogger.Info("Context done, finish streaming", "path", req.Path)
+			logger.Info("Context done, finish streaming")
 			return nil
 		default:
-			msg, event := d.client.ConsumerPull()
-			if event == nil {
-				continue
+			now := time.Now()
+			if aggregation != nil {
+				pending.add(now, aggregation.closeWindows(now)...)
 			}
-			frame := data.NewFrame("response")
-			frame.Fields = append(frame.Fields,
-				data.NewField("time", nil, make([]time.Time, 1)),
-			)
-			var frame_time time.Time
-			if d.client.TimestampMode == "now" {
-				frame_time = time.Now()
-			} else {
-				frame_time = msg.Timestamp
+			if client.FromBeginning && client.HistoryRead() {
+				logger.Info("History read, tailing the topic")
+				// Reconnections resume at the auto offset reset from now on.
+				client.FromBeginning = false
+				phase = "streaming"
 			}
-			log.DefaultLogger.Info("Offset", msg.Offset)
-			log.DefaultLogger.Info("timestamp", frame_time)
-			frame.Fields[0].Set(0, frame_time)
-
-			cnt := 1
-
-			for key, value := range msg.Value {
-				frame.Fields = append(frame.Fields,
-					data.NewField(key, nil, make([]float64, 1)))
-				frame.Fields[cnt].Set(0, value)
-				cnt++
+			if pending.due(now) && atomic.LoadInt32(paused) == 1 {
+				// The graph of a paused stream stays as it is.
+				pending.take()
+			}
+			if pending.due(now) {
+				status := streamStatus{Status: phase, Topic: qm.Topic, Partition: qm.Partition, GroupId: client.GroupId}
+				for _, frame := range streamFrames(sortRows(pending.take(), qm.SortBy), qm.frameName(), qm.FrameMode, status) {
+					if qm.IncludeMetadata && uid != "" {
+						setOffsetLinks(frame, uid, frame.Meta.Custom.(streamStatus).Topic, qm.BootstrapServers)
+					}
+					if err := sender.SendFrame(frame, schemas.include(frame)); err != nil {
+						logger.Error("Error sending frame", "error", err)
+					}
+					lastFrame, lastSent = frame, now
+				}
+			}
+			if len(pending.rows) == 0 && client.Ended() {
+				logger.Info("End of the time range reached, finish streaming")
+				if err := sender.SendFrame(newEndedFrame(qm.frameName(), qm, client.EndTime), data.IncludeAll); err != nil {
+					logger.Error("Error sending frame", "error", err)
+				}
+				return nil
+			}
+			// Idle topics get an empty frame now and then, so that the
+			// proxies don't drop the connection of the panel.
+			if keepalive > 0 && now.Sub(lastSent) >= keepalive {
+				if err := sender.SendFrame(lastFrame.EmptyCopy(), data.IncludeDataOnly); err != nil {
+					logger.Error("Error sending keepalive frame", "error", err)
+				}
+				lastSent = now
 			}
 
-			err := sender.SendFrame(frame, data.IncludeAll)
-
+			msg, err := client.ConsumerPull()
+			if kafka_client.IsAllBrokersDownError(err) || errors.Is(err, kafka_client.ErrNoConsumer) {
+				reconnectAttempts++
+				if limit := d.settings.MaxReconnectAttempts; limit > 0 && reconnectAttempts > limit {
+					err = fmt.Errorf("gave up reconnecting after %d attempts: %w", limit, err)
+					logger.Error("Error consuming message", "error", err)
+					if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+						logger.Error("Error sending frame", "error", err)
+					}
+					return err
+				}
+				logger.Warn("Reconnecting", "attempt", reconnectAttempts, "error", err)
+				d.recordError(err)
+				streamReconnects.Inc()
+				if err := d.reconnect(ctx, &client, qm); err != nil && ctx.Err() == nil {
+					logger.Error("Error reconnecting", "error", err)
+					d.recordError(err)
+					lastFrame, lastSent = newReconnectingFrame(qm.frameName(), qm, err), time.Now()
+					if err := sender.SendFrame(lastFrame, schemas.include(lastFrame)); err != nil {
+						logger.Error("Error sending frame", "error", err)
+					}
+				}
+				continue
+			}
+			if kafka_client.IsTopicAuthorizationError(err) {
+				// Retrying is pointless until the ACLs change.
+				err = fmt.Errorf("not authorized to read topic %s, check its ACLs: %w", qm.Topic, err)
+				logger.Error("Error consuming message", "error", err)
+				if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+					logger.Error("Error sending frame", "error", err)
+				}
+				return err
+			}
+			if kafka_client.IsAutoOffsetResetError(err) {
+				err = fmt.Errorf("no committed offset to resume from, with the %s auto offset reset: %w",
+					kafka_client.AUTO_OFFSET_RESET_ERROR, err)
+				logger.Error("Error consuming message", "error", err)
+				if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+					logger.Error("Error sending frame", "error", err)
+				}
+				return err
+			}
 			if err != nil {
-				log.DefaultLogger.Error("Error sending frame", "error", err)
+				logger.Error("Error consuming message", "error", err)
+				continue
+			}
+			if msg == nil {
+				continue
+			}
+			reconnectAttempts = 0
+			consumedMessages.Inc()
+			if keyMatches != nil && !keyMatches(msg.Key) {
+				continue
+			}
+			if !sampling.keep(time.Now()) {
+				continue
+			}
+			if msg.DecodeError != nil {
+				undecodedMessages.Inc()
+				if err := decodeErrors.record(msg.DecodeError); err != nil {
+					logger.Error("Error decoding message", "offset", msg.Offset, "error", err)
+					if err := sender.SendFrame(newFailedFrame(qm.frameName(), qm, err), data.IncludeAll); err != nil {
+						logger.Error("Error sending frame", "error", err)
+					}
+					return err
+				}
+				logger.Warn("Error decoding message", "offset", msg.Offset, "error", msg.DecodeError)
+				continue
+			}
+			decodeErrors.record(nil)
+			if msg.Tombstone && qm.SkipTombstones {
 				continue
 			}
+
+			rowTime := msg.Timestamp
+			if client.TimestampMode == "now" {
+				rowTime = time.Now()
+			}
+			logger.Debug("Message consumed", "messagePartition", msg.Partition, "offset", msg.Offset, "timestamp", rowTime)
+
+			seq++
+			rows := messageRows(msg, rowTime, qm)
+			if qm.IncludeSeq {
+				setSeq(rows, seq)
+			}
+			for _, row := range rows {
+				if qm.StrictTimeRange && !inTimeRange(row.time, client.StartTime, client.EndTime) {
+					continue
+				}
+				if fields.limit(row) > 0 && !overflowWarned {
+					logger.Warn("Too many distinct fields, the new ones are counted in "+OVERFLOW_FIELD, "maxFields", fields.max)
+					overflowWarned = true
+				}
+				if aggregation != nil {
+					aggregation.add(row)
+				} else {
+					pending.add(time.Now(), row)
+				}
+			}
 		}
 	}
 }
 
+// Actions published to the channel of a stream, which pause the frames of
+// the stream and resume them, when the datasource allows it.
+const STREAM_ACTION_PAUSE = "pause"
+const STREAM_ACTION_RESUME = "resume"
+
+var STREAM_ACTIONS = []string{STREAM_ACTION_PAUSE, STREAM_ACTION_RESUME}
+
+type streamControl struct {
+	Action string `json:"action"`
+}
+
 func (d *KafkaDatasource) PublishStream(_ context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
 	log.DefaultLogger.Info("PublishStream called", "request", req)
 
+	if !d.settings.AllowStreamControl {
+		return &backend.PublishStreamResponse{
+			Status: backend.PublishStreamStatusPermissionDenied,
+		}, nil
+	}
+
+	var control streamControl
+	if err := json.Unmarshal(req.Data, &control); err != nil {
+		return nil, fmt.Errorf("invalid stream control: %w", err)
+	}
+	if !contains(STREAM_ACTIONS, control.Action) {
+		return nil, fmt.Errorf("invalid stream action %q, expected one of %s",
+			control.Action, strings.Join(STREAM_ACTIONS, ", "))
+	}
+
+	paused := d.pausedFlag(req.Path)
+	if paused == nil {
+		return &backend.PublishStreamResponse{
+			Status: backend.PublishStreamStatusNotFound,
+		}, nil
+	}
+	if control.Action == STREAM_ACTION_PAUSE {
+		atomic.StoreInt32(paused, 1)
+	} else {
+		atomic.StoreInt32(paused, 0)
+	}
+	log.DefaultLogger.Info("Stream controlled", "path", req.Path, "action", control.Action)
+
 	return &backend.PublishStreamResponse{
-		Status: backend.PublishStreamStatusPermissionDenied,
+		Status: backend.PublishStreamStatusOK,
 	}, nil
 }
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
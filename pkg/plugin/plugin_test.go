@@ -2,6 +2,7 @@ package plugin_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -28,3 +29,57 @@ func TestQueryData(t *testing.T) {
 		t.Fatal("QueryData must return a response")
 	}
 }
+
+func TestQueryDataInvalidQuery(t *testing.T) {
+	ds := plugin.KafkaDatasource{}
+
+	for query, expected := range map[string]string{
+		`{"partition": 0}`:                                                   "invalid Kafka query: missing topicName",
+		`{"topicName": "test", "partition": -2}`:                             "invalid Kafka query: invalid partition -2",
+		`{"topicName": "test", "partition": "0"}`:                            "invalid Kafka query: partition must be of type int32, got string",
+		`{"topicName": "test", "partition": -1, "autoOffsetReset": "error"}`: "invalid Kafka query: auto offset reset \"error\" requires a single partition",
+	} {
+		resp, err := ds.QueryData(
+			context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{
+					{RefID: "A", JSON: []byte(query)},
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resp.Responses["A"].Error; err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %q for %s, got %v", expected, query, err)
+		}
+	}
+}
+
+func TestQueryDataSuppressInitialFrame(t *testing.T) {
+	ds := plugin.KafkaDatasource{}
+
+	for query, fields := range map[string]int{
+		`{"topicName": "test", "partition": 0, "withStreaming": true}`:                               2,
+		`{"topicName": "test", "partition": 0, "withStreaming": true, "suppressInitialFrame": true}`: 0,
+	} {
+		resp, err := ds.QueryData(
+			context.Background(),
+			&backend.QueryDataRequest{
+				PluginContext: backend.PluginContext{
+					DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "kafka"},
+				},
+				Queries: []backend.DataQuery{
+					{RefID: "A", JSON: []byte(query)},
+				},
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := resp.Responses["A"].Frames
+		if len(frames) != 1 || len(frames[0].Fields) != fields || frames[0].Meta == nil || frames[0].Meta.Channel == "" {
+			t.Errorf("expected a frame of %d fields with the channel for %s, got %v", fields, query, frames)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+const DEFAULT_PREVIEW_COUNT int64 = 10
+const MAX_PREVIEW_COUNT int64 = 100
+
+func (d *KafkaDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	log.DefaultLogger.Info("CallResource called", "path", req.Path)
+
+	reqUrl, err := url.Parse(req.URL)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err.Error())
+	}
+	params := reqUrl.Query()
+
+	switch req.Path {
+	case "preview":
+		return d.handlePreview(params, sender)
+	case "validate":
+		return d.handleValidate(req.Body, sender)
+	case "topics":
+		return d.handleTopics(params, sender)
+	case "matchTopics":
+		return d.handleMatchTopics(params, sender)
+	case "diagnostics":
+		return sendJSON(sender, http.StatusOK, d.diagnostics())
+	case "refresh":
+		d.metadata.Invalidate()
+		return sendJSON(sender, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		return sendError(sender, http.StatusNotFound, "unknown resource: "+req.Path)
+	}
+}
+
+func (d *KafkaDatasource) handlePreview(params url.Values, sender backend.CallResourceResponseSender) error {
+	topic := params.Get("topic")
+	if topic == "" {
+		return sendError(sender, http.StatusBadRequest, "topic is required")
+	}
+
+	partition := int64(0)
+	if value := params.Get("partition"); value != "" {
+		var err error
+		partition, err = strconv.ParseInt(value, 10, 32)
+		if err != nil || partition < 0 {
+			return sendError(sender, http.StatusBadRequest, "partition must be a non-negative integer")
+		}
+	}
+
+	offset := int64(-1)
+	if value := params.Get("offset"); value != "" {
+		var err error
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return sendError(sender, http.StatusBadRequest, "offset must be a non-negative integer")
+		}
+	}
+
+	count := DEFAULT_PREVIEW_COUNT
+	if value := params.Get("n"); value != "" {
+		var err error
+		count, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || count <= 0 || count > MAX_PREVIEW_COUNT {
+			return sendError(sender, http.StatusBadRequest, "n must be between 1 and "+strconv.FormatInt(MAX_PREVIEW_COUNT, 10))
+		}
+	}
+
+	qm := queryModel{Topic: topic, BootstrapServers: params.Get("bootstrapServers")}
+	if err := d.checkInternalTopic(qm); err != nil {
+		return sendError(sender, http.StatusForbidden, err.Error())
+	}
+
+	// The links of the streams overriding the bootstrap servers preview the
+	// messages of their servers.
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return sendError(sender, http.StatusForbidden, err.Error())
+	}
+	messages, err := client.Preview(topic, int32(partition), offset, count)
+	if err != nil {
+		return sendError(sender, http.StatusInternalServerError, err.Error())
+	}
+
+	return sendJSON(sender, http.StatusOK, messages)
+}
+
+// handleTopics lists the topics of the cluster, leaving out the internal
+// ones unless internal=true.
+func (d *KafkaDatasource) handleTopics(params url.Values, sender backend.CallResourceResponseSender) error {
+	topics, err := d.newClient().Topics()
+	if err != nil {
+		return sendError(sender, http.StatusInternalServerError, err.Error())
+	}
+
+	if params.Get("internal") != "true" {
+		visible := topics[:0]
+		for _, topic := range topics {
+			if !topic.Internal {
+				visible = append(visible, topic)
+			}
+		}
+		topics = visible
+	}
+
+	return sendJSON(sender, http.StatusOK, topics)
+}
+
+// handleMatchTopics lists the names of the topics a topic pattern currently
+// subscribes to, leaving out the internal ones unless internal=true.
+func (d *KafkaDatasource) handleMatchTopics(params url.Values, sender backend.CallResourceResponseSender) error {
+	pattern := params.Get("pattern")
+	if pattern == "" {
+		return sendError(sender, http.StatusBadRequest, "pattern is required")
+	}
+	matcher, err := topicPattern(pattern)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err.Error())
+	}
+
+	topics, err := d.newClient().Topics()
+	if err != nil {
+		return sendError(sender, http.StatusInternalServerError, err.Error())
+	}
+
+	return sendJSON(sender, http.StatusOK, matchTopics(topics, matcher, params.Get("internal") == "true"))
+}
+
+// topicPattern compiles a topic pattern, anchored at the start of the names
+// like the subscriptions, whether or not it starts with ^.
+func topicPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "^") {
+		pattern = "^" + pattern
+	}
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic pattern %q: %w", pattern, err)
+	}
+	return matcher, nil
+}
+
+// matchTopics returns the names of the topics matching the pattern, in the
+// order of the topics.
+func matchTopics(topics []kafka_client.TopicInfo, matcher *regexp.Regexp, internal bool) []string {
+	names := []string{}
+	for _, topic := range topics {
+		if (internal || !topic.Internal) && matcher.MatchString(topic.Name) {
+			names = append(names, topic.Name)
+		}
+	}
+	return names
+}
+
+// validationError points the query editor at the setting to fix.
+type validationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type validationResult struct {
+	Valid  bool              `json:"valid"`
+	Errors []validationError `json:"errors"`
+}
+
+// handleValidate checks a query against the cluster: the topic must exist,
+// the partition must be one of its partitions and the offsets of a range
+// must be within its watermarks.
+func (d *KafkaDatasource) handleValidate(body []byte, sender backend.CallResourceResponseSender) error {
+	qm, err := parseQuery(body)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, "invalid query: "+err.Error())
+	}
+	d.applyQueryDefaults(&qm)
+
+	result := validationResult{Errors: d.validateQuery(qm)}
+	result.Valid = len(result.Errors) == 0
+
+	return sendJSON(sender, http.StatusOK, result)
+}
+
+func (d *KafkaDatasource) validateQuery(qm queryModel) []validationError {
+	problems := []validationError{}
+	if err := qm.validate(); err != nil {
+		var fieldErr queryError
+		if errors.As(err, &fieldErr) {
+			return append(problems, validationError{Field: fieldErr.Field, Message: fieldErr.Message})
+		}
+		return append(problems, validationError{Field: "query", Message: err.Error()})
+	}
+	if qm.Mode != QUERY_MODE_OFFSETS {
+		if err := d.checkInternalTopic(qm); err != nil {
+			return append(problems, validationError{Field: "topicName", Message: err.Error()})
+		}
+	}
+
+	client, err := d.queryClient(qm)
+	if err != nil {
+		return append(problems, validationError{Field: "bootstrapServers", Message: err.Error()})
+	}
+	offsets, err := client.WatermarkOffsets(qm.Topic)
+	if kafka_client.IsUnknownTopicError(err) {
+		return append(problems, validationError{Field: "topicName", Message: fmt.Sprintf("topic %s does not exist", qm.Topic)})
+	}
+	if err != nil {
+		return append(problems, validationError{Field: "topicName", Message: err.Error()})
+	}
+	if qm.Partition == kafka_client.ALL_PARTITIONS {
+		return problems
+	}
+
+	var partition *kafka_client.PartitionOffsets
+	for i := range offsets {
+		if offsets[i].Partition == qm.Partition {
+			partition = &offsets[i]
+		}
+	}
+	if partition == nil {
+		return append(problems, validationError{
+			Field:   "partition",
+			Message: fmt.Sprintf("topic %s has %d partitions, %d is not one of them", qm.Topic, len(offsets), qm.Partition),
+		})
+	}
+
+	if qm.FromOffset != nil && (*qm.FromOffset < partition.Low || *qm.FromOffset >= partition.High) {
+		problems = append(problems, validationError{
+			Field:   "fromOffset",
+			Message: fmt.Sprintf("offset %d is out of the available range %d to %d", *qm.FromOffset, partition.Low, partition.High-1),
+		})
+	}
+	if qm.ToOffset != nil && *qm.ToOffset < partition.Low {
+		problems = append(problems, validationError{
+			Field:   "toOffset",
+			Message: fmt.Sprintf("offset %d was deleted, the earliest available offset is %d", *qm.ToOffset, partition.Low),
+		})
+	}
+
+	return problems
+}
+
+func sendJSON(sender backend.CallResourceResponseSender, status int, body interface{}) error {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status:  status,
+		Headers: map[string][]string{"Content-Type": {"application/json"}},
+		Body:    bytes,
+	})
+}
+
+func sendError(sender backend.CallResourceResponseSender, status int, message string) error {
+	return sendJSON(sender, status, map[string]string{"error": message})
+}
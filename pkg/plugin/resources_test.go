@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+type recordingSender struct {
+	status int
+}
+
+func (s *recordingSender) Send(resp *backend.CallResourceResponse) error {
+	s.status = resp.Status
+	return nil
+}
+
+func TestPreviewInternalTopic(t *testing.T) {
+	d := &KafkaDatasource{}
+	sender := &recordingSender{}
+	if err := d.handlePreview(url.Values{"topic": {"__consumer_offsets"}}, sender); err != nil {
+		t.Fatal(err)
+	}
+	if sender.status != http.StatusForbidden {
+		t.Errorf("expected the preview of an internal topic to be refused, got status %d", sender.status)
+	}
+}
+
+func TestPreviewBootstrapServers(t *testing.T) {
+	d := &KafkaDatasource{}
+	sender := &recordingSender{}
+	params := url.Values{"topic": {"orders"}, "bootstrapServers": {"other:9092"}}
+	if err := d.handlePreview(params, sender); err != nil {
+		t.Fatal(err)
+	}
+	if sender.status != http.StatusForbidden {
+		t.Errorf("expected the preview of servers not allowed to be refused, got status %d", sender.status)
+	}
+}
+
+func TestMatchTopics(t *testing.T) {
+	topics := []kafka_client.TopicInfo{
+		{Name: "__orders-internal", Internal: true},
+		{Name: "orders-eu"},
+		{Name: "orders-us"},
+		{Name: "payments"},
+		{Name: "returns-orders-eu"},
+	}
+
+	for pattern, expected := range map[string][]string{
+		"orders-.*":      {"orders-eu", "orders-us"},
+		"^orders-(eu|x)": {"orders-eu"},
+		".*orders":       {"orders-eu", "orders-us", "returns-orders-eu"},
+		"shipments":      {},
+	} {
+		matcher, err := topicPattern(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched := matchTopics(topics, matcher, false); !reflect.DeepEqual(matched, expected) {
+			t.Errorf("%s: expected %v, got %v", pattern, expected, matched)
+		}
+	}
+
+	matcher, _ := topicPattern("_")
+	if matched := matchTopics(topics, matcher, true); !reflect.DeepEqual(matched, []string{"__orders-internal"}) {
+		t.Errorf("expected the internal topics with internal, got %v", matched)
+	}
+	if _, err := topicPattern("orders-("); err == nil {
+		t.Errorf("expected an invalid pattern to fail")
+	}
+}
+
+func TestValidateQueryFields(t *testing.T) {
+	d := &KafkaDatasource{}
+	for query, field := range map[string]string{
+		`{"partition":0}`:                                                "topicName",
+		`{"topicName":"test","partition":-2}`:                            "partition",
+		`{"topicName":"^test-.*","partition":0}`:                         "partition",
+		`{"topicName":"test","mode":"tail"}`:                             "mode",
+		`{"topicName":"test","format":"yaml"}`:                           "format",
+		`{"topicName":"test","csvDelimiter":";;"}`:                       "csvDelimiter",
+		`{"topicName":"test","timeFieldFormat":"iso"}`:                   "timeFieldFormat",
+		`{"topicName":"test","frameMode":"split"}`:                       "frameMode",
+		`{"topicName":"test","sortBy":"key"}`:                            "sortBy",
+		`{"topicName":"test","maxFields":-1}`:                            "maxFields",
+		`{"topicName":"test","sampleRate":-1}`:                           "sampleRate",
+		`{"topicName":"test","maxMessagesPerSecond":-1}`:                 "maxMessagesPerSecond",
+		`{"topicName":"^test","partition":-1,"parallelPartitions":true}`: "parallelPartitions",
+		`{"topicName":"test","maxStringLength":-1}`:                      "maxStringLength",
+		`{"topicName":"test","maxConsecutiveDecodeErrors":-1}`:           "maxConsecutiveDecodeErrors",
+		`{"topicName":"test","eventMode":true,"aggregation":"avg"}`:      "eventMode",
+		`{"topicName":"test","aggregation":"median"}`:                    "aggregation",
+		`{"topicName":"test","aggregationWindow":"soon"}`:                "aggregationWindow",
+		`{"topicName":"test","startRelative":"-5m"}`:                     "startRelative",
+		`{"topicName":"test","keyFilter":"^("}`:                          "keyFilter",
+		`{"topicName":"test","toOffset":5}`:                              "fromOffset",
+		`{"topicName":"test","fromOffset":5}`:                            "toOffset",
+		`{"topicName":"test","partition":0,"fromOffset":5,"toOffset":1}`: "fromOffset",
+		`{"topicName":"test","includeFields":["["]}`:                     "includeFields",
+		`{"topicName":"test","excludeFields":["["]}`:                     "excludeFields",
+	} {
+		qm, err := parseQuery([]byte(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems := d.validateQuery(qm)
+		if len(problems) != 1 || problems[0].Field != field {
+			t.Errorf("%s: expected an error of field %s, got %v", query, field, problems)
+		}
+	}
+}
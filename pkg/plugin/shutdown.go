@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Time the streams are given to close their consumers when the plugin shuts
+// down, leaving their consumer groups at once rather than after the session
+// timeout.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+var ErrShuttingDown = errors.New("the plugin is shutting down")
+
+// The datasources of the process, disposed when it shuts down, and the
+// streams of all the datasources, awaited until they closed their consumer.
+// No stream starts once the shutdown began, so that none is added to the
+// running ones while they are awaited.
+var (
+	datasourcesMu  sync.Mutex
+	datasources    = make(map[*KafkaDatasource]struct{})
+	shuttingDown   bool
+	runningStreams sync.WaitGroup
+)
+
+func registerDatasource(d *KafkaDatasource) {
+	datasourcesMu.Lock()
+	defer datasourcesMu.Unlock()
+
+	datasources[d] = struct{}{}
+}
+
+func unregisterDatasource(d *KafkaDatasource) {
+	datasourcesMu.Lock()
+	defer datasourcesMu.Unlock()
+
+	delete(datasources, d)
+}
+
+// startStream counts a stream among the running ones, unless the plugin is
+// shutting down. The stream calls runningStreams.Done when it stopped.
+func startStream() error {
+	datasourcesMu.Lock()
+	defer datasourcesMu.Unlock()
+
+	if shuttingDown {
+		return ErrShuttingDown
+	}
+	runningStreams.Add(1)
+
+	return nil
+}
+
+// Shutdown disposes all the datasources, cancelling their streams, and waits
+// for the streams to close their consumers, up to the timeout. It returns
+// false when some streams were still running.
+func Shutdown(timeout time.Duration) bool {
+	datasourcesMu.Lock()
+	shuttingDown = true
+	disposing := make([]*KafkaDatasource, 0, len(datasources))
+	for d := range datasources {
+		disposing = append(disposing, d)
+	}
+	datasourcesMu.Unlock()
+
+	log.DefaultLogger.Info("Shutting down", "datasources", len(disposing))
+	for _, d := range disposing {
+		d.Dispose()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runningStreams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.DefaultLogger.Warn("Streams still running after the shutdown timeout", "timeout", timeout)
+		return false
+	}
+}
@@ -0,0 +1,417 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+// streamStatus is echoed in the frame metadata so that users can tell what
+// the backend actually subscribed to, and where the messages of every frame
+// come from.
+type streamStatus struct {
+	Status    string `json:"status"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    string `json:"offset"`
+	GroupId   string `json:"groupId,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// streamLogger tags the log lines of a stream with its context, so that the
+// lines of concurrent panels can be told apart.
+type streamLogger []interface{}
+
+func newStreamLogger(req *backend.RunStreamRequest, qm queryModel) streamLogger {
+	var uid string
+	if req.PluginContext.DataSourceInstanceSettings != nil {
+		uid = req.PluginContext.DataSourceInstanceSettings.UID
+	}
+	return streamLogger{"datasource", uid, "path", req.Path, "topic", qm.Topic, "partition", qm.Partition}
+}
+
+func (l streamLogger) args(args []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l)+len(args)), l...), args...)
+}
+
+func (l streamLogger) Debug(msg string, args ...interface{}) {
+	log.DefaultLogger.Debug(msg, l.args(args)...)
+}
+
+func (l streamLogger) Info(msg string, args ...interface{}) {
+	log.DefaultLogger.Info(msg, l.args(args)...)
+}
+
+func (l streamLogger) Warn(msg string, args ...interface{}) {
+	log.DefaultLogger.Warn(msg, l.args(args)...)
+}
+
+func (l streamLogger) Error(msg string, args ...interface{}) {
+	log.DefaultLogger.Error(msg, l.args(args)...)
+}
+
+// schemaTracker remembers the schema of the last frame sent by a stream, so
+// that the frames repeating it are sent without it: the panels then append
+// their values to the fields they have, saving the schema and metadata on
+// every frame of the busy topics. A Grafana Live channel keeps a single
+// schema, which the data-only frames carrying no name are appended to, so the
+// frames of alternating schemas, e.g. of the Per topic frame mode, are all
+// sent with theirs. The last frame sent is the initial data of the panels
+// joining the stream, its schema being the one of the channel. A nil tracker
+// sends every frame with its schema.
+type schemaTracker struct {
+	mu     sync.Mutex
+	schema string
+	last   *data.Frame
+}
+
+// include tells how to send the frame, recording its schema when it changed.
+func (tracker *schemaTracker) include(frame *data.Frame) data.FrameInclude {
+	if tracker == nil {
+		return data.IncludeAll
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.last = frame
+	schema := frameSchema(frame)
+	if schema == tracker.schema {
+		return data.IncludeDataOnly
+	}
+	tracker.schema = schema
+
+	return data.IncludeAll
+}
+
+// initialData returns the schema of the last frame sent, nil before the
+// first one.
+func (tracker *schemaTracker) initialData() (*backend.InitialData, error) {
+	if tracker == nil {
+		return nil, nil
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.last == nil {
+		return nil, nil
+	}
+	return backend.NewInitialFrame(tracker.last, data.IncludeSchemaOnly)
+}
+
+// frameSchema identifies the name, fields and metadata of a frame, but the
+// offset of its last message, which changes with every frame.
+func frameSchema(frame *data.Frame) string {
+	var schema strings.Builder
+	schema.WriteString(frame.Name)
+	for _, field := range frame.Fields {
+		config, _ := json.Marshal(field.Config)
+		fmt.Fprintf(&schema, "\n%s %v %v %s", field.Name, field.Type(), field.Labels, config)
+	}
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		if status, ok := meta.Custom.(streamStatus); ok {
+			status.Offset = ""
+			meta.Custom = status
+		}
+		encoded, _ := json.Marshal(meta)
+		fmt.Fprintf(&schema, "\n%s", encoded)
+	}
+
+	return schema.String()
+}
+
+// newStartedFrame builds the zero-row frame sent when a stream starts.
+func newStartedFrame(name string, client *kafka_client.KafkaClient, qm queryModel) *data.Frame {
+	status := streamStatus{
+		Status:    "started",
+		Topic:     qm.Topic,
+		Partition: qm.Partition,
+		Offset:    client.DescribeStartOffset(),
+		GroupId:   client.GroupId,
+	}
+
+	frame := data.NewFrame(name, data.NewField("time", nil, []time.Time{}))
+	frame.SetMeta(&data.FrameMeta{
+		Custom: status,
+		Notices: []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Consuming topic %s, partition %d, from offset %s", status.Topic, status.Partition, status.Offset),
+		}},
+	})
+
+	return frame
+}
+
+// newFailedFrame builds the zero-row frame sent when a stream gives up, so
+// that the panel shows why it stopped updating.
+func newFailedFrame(name string, qm queryModel, err error) *data.Frame {
+	status := streamStatus{
+		Status:    "failed",
+		Topic:     qm.Topic,
+		Partition: qm.Partition,
+		Error:     err.Error(),
+	}
+
+	frame := data.NewFrame(name, data.NewField("time", nil, []time.Time{}))
+	frame.SetMeta(&data.FrameMeta{
+		Custom: status,
+		Notices: []data.Notice{{
+			Severity: data.NoticeSeverityError,
+			Text:     fmt.Sprintf("Stopped consuming topic %s, partition %d: %s", status.Topic, status.Partition, status.Error),
+		}},
+	})
+
+	return frame
+}
+
+// newReconnectingFrame builds the zero-row frame sent when a stream failed to
+// reconnect, so that the panel shows why it isn't updating while the stream
+// retries.
+func newReconnectingFrame(name string, qm queryModel, err error) *data.Frame {
+	status := streamStatus{
+		Status:    "reconnecting",
+		Topic:     qm.Topic,
+		Partition: qm.Partition,
+		Error:     err.Error(),
+	}
+
+	frame := data.NewFrame(name, data.NewField("time", nil, []time.Time{}))
+	frame.SetMeta(&data.FrameMeta{
+		Custom: status,
+		Notices: []data.Notice{{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("Failed to reconnect to topic %s, partition %d, retrying: %s",
+				status.Topic, status.Partition, status.Error),
+		}},
+	})
+
+	return frame
+}
+
+// newEndedFrame builds the zero-row frame sent when a stream read its time
+// range up to the end, so that the panel shows why it stopped updating.
+func newEndedFrame(name string, qm queryModel, end time.Time) *data.Frame {
+	status := streamStatus{
+		Status:    "ended",
+		Topic:     qm.Topic,
+		Partition: qm.Partition,
+	}
+
+	frame := data.NewFrame(name, data.NewField("time", nil, []time.Time{}))
+	frame.SetMeta(&data.FrameMeta{
+		Custom: status,
+		Notices: []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text: fmt.Sprintf("Stopped consuming topic %s, partition %d, at the end of the time range, %s",
+				status.Topic, status.Partition, end.UTC().Format(time.RFC3339)),
+		}},
+	})
+
+	return frame
+}
+
+// batch holds the rows waiting to be sent, along with the arrival time of
+// the first one.
+type batch struct {
+	rows  []frameRow
+	since time.Time
+}
+
+func (b *batch) add(now time.Time, rows ...frameRow) {
+	if len(b.rows) == 0 && len(rows) > 0 {
+		b.since = now
+	}
+	b.rows = append(b.rows, rows...)
+}
+
+// due tells whether the first row waited for STREAM_DEBOUNCE.
+func (b *batch) due(now time.Time) bool {
+	return len(b.rows) > 0 && now.Sub(b.since) >= STREAM_DEBOUNCE
+}
+
+func (b *batch) take() []frameRow {
+	rows := b.rows
+	b.rows = nil
+	return rows
+}
+
+// sampler deterministically drops messages of high throughput topics, keeping
+// one message in rate and at most maxPerSecond messages every second.
+type sampler struct {
+	rate         int64
+	maxPerSecond int64
+
+	count       int64
+	windowStart time.Time
+	windowCount int64
+}
+
+func (s *sampler) keep(now time.Time) bool {
+	s.count++
+	if s.rate > 1 && (s.count-1)%s.rate != 0 {
+		return false
+	}
+
+	if s.maxPerSecond > 0 {
+		if now.Sub(s.windowStart) >= time.Second {
+			s.windowStart = now
+			s.windowCount = 0
+		}
+		if s.windowCount >= s.maxPerSecond {
+			return false
+		}
+		s.windowCount++
+	}
+
+	return true
+}
+
+// decodeErrorLimit counts the consecutive messages of a stream which failed
+// to decode, giving up at the maximum of the query, 0 tolerating them all.
+type decodeErrorLimit struct {
+	max         int64
+	consecutive int64
+}
+
+// record counts the decode error of a message, nil for a decoded message
+// resetting the count, and returns the error to give up with once the
+// maximum is reached.
+func (limit *decodeErrorLimit) record(err error) error {
+	if err == nil {
+		limit.consecutive = 0
+		return nil
+	}
+	limit.consecutive++
+	if limit.max > 0 && limit.consecutive >= limit.max {
+		// Likely the wrong format rather than a few bad records.
+		return fmt.Errorf("gave up after %d consecutive messages failed to decode, check the format: %w", limit.max, err)
+	}
+
+	return nil
+}
+
+const AGGREGATION_COUNT = "count"
+const AGGREGATION_SUM = "sum"
+const AGGREGATION_AVG = "avg"
+const AGGREGATION_MIN = "min"
+const AGGREGATION_MAX = "max"
+
+var AGGREGATIONS = []string{AGGREGATION_COUNT, AGGREGATION_SUM, AGGREGATION_AVG, AGGREGATION_MIN, AGGREGATION_MAX}
+
+const DEFAULT_AGGREGATION_WINDOW = time.Second
+
+type fieldAggregate struct {
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+type aggregationWindow struct {
+	start  time.Time
+	count  int64
+	fields map[string]*fieldAggregate
+}
+
+// aggregator reduces the rows of every tumbling window to a single row
+// holding the count of messages or an aggregate of each numeric field.
+type aggregator struct {
+	function string
+	window   time.Duration
+	windows  []*aggregationWindow
+}
+
+func (a *aggregator) add(row frameRow) {
+	window := a.windowAt(row.time.Truncate(a.window))
+	window.count++
+
+	for key, value := range row.values {
+		number, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		field, exists := window.fields[key]
+		if !exists {
+			field = &fieldAggregate{min: number, max: number}
+			window.fields[key] = field
+		}
+		field.count++
+		field.sum += number
+		if number < field.min {
+			field.min = number
+		}
+		if number > field.max {
+			field.max = number
+		}
+	}
+}
+
+// windowAt returns the window starting at start, keeping the windows sorted.
+func (a *aggregator) windowAt(start time.Time) *aggregationWindow {
+	i := 0
+	for ; i < len(a.windows); i++ {
+		if a.windows[i].start.Equal(start) {
+			return a.windows[i]
+		}
+		if a.windows[i].start.After(start) {
+			break
+		}
+	}
+
+	window := &aggregationWindow{start: start, fields: make(map[string]*fieldAggregate)}
+	a.windows = append(a.windows, nil)
+	copy(a.windows[i+1:], a.windows[i:])
+	a.windows[i] = window
+
+	return window
+}
+
+// closeWindows returns a row for every window which can't receive messages
+// anymore: those which ended before now, and all but the latest one.
+func (a *aggregator) closeWindows(now time.Time) []frameRow {
+	var rows []frameRow
+	latest := len(a.windows) - 1
+	open := a.windows[:0]
+
+	for i, window := range a.windows {
+		if i < latest || !window.start.Add(a.window).After(now) {
+			rows = append(rows, a.result(window))
+		} else {
+			open = append(open, window)
+		}
+	}
+	a.windows = open
+
+	return rows
+}
+
+func (a *aggregator) result(window *aggregationWindow) frameRow {
+	row := frameRow{time: window.start, values: make(map[string]interface{})}
+	if a.function == AGGREGATION_COUNT {
+		row.values[AGGREGATION_COUNT] = float64(window.count)
+		return row
+	}
+
+	for key, field := range window.fields {
+		switch a.function {
+		case AGGREGATION_SUM:
+			row.values[key] = field.sum
+		case AGGREGATION_AVG:
+			row.values[key] = field.sum / float64(field.count)
+		case AGGREGATION_MIN:
+			row.values[key] = field.min
+		case AGGREGATION_MAX:
+			row.values[key] = field.max
+		}
+	}
+
+	return row
+}
@@ -0,0 +1,339 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/hoptical/grafana-kafka-datasource/pkg/kafka_client"
+)
+
+func TestAggregatorTumblingWindows(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	aggregation := aggregator{function: AGGREGATION_AVG, window: 10 * time.Second}
+
+	aggregation.add(frameRow{time: start.Add(time.Second), values: map[string]interface{}{"cpu": 1.0}})
+	aggregation.add(frameRow{time: start.Add(2 * time.Second), values: map[string]interface{}{"cpu": 3.0, "host": "a"}})
+	aggregation.add(frameRow{time: start.Add(11 * time.Second), values: map[string]interface{}{"cpu": 5.0}})
+
+	rows := aggregation.closeWindows(start.Add(12 * time.Second))
+	if len(rows) != 1 {
+		t.Fatalf("expected only the first window to be closed, got %d rows", len(rows))
+	}
+	if !rows[0].time.Equal(start) || rows[0].values["cpu"] != 2.0 {
+		t.Errorf("unexpected first window: %v", rows[0])
+	}
+	if _, exists := rows[0].values["host"]; exists {
+		t.Error("non-numeric fields must not be aggregated")
+	}
+
+	rows = aggregation.closeWindows(start.Add(20 * time.Second))
+	if len(rows) != 1 || rows[0].values["cpu"] != 5.0 {
+		t.Errorf("unexpected second window: %v", rows)
+	}
+}
+
+func TestDisposeCancelsStreams(t *testing.T) {
+	d := &KafkaDatasource{}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.addStream("a", &kafka_client.KafkaClient{}, cancel)
+
+	d.Dispose()
+	if ctx.Err() == nil {
+		t.Fatal("expected the stream to be cancelled")
+	}
+
+	late, cancelLate := context.WithCancel(context.Background())
+	d.addStream("b", &kafka_client.KafkaClient{}, cancelLate)
+	if late.Err() == nil {
+		t.Fatal("expected a stream started after dispose to be cancelled")
+	}
+}
+
+func TestShutdownRefusesStreams(t *testing.T) {
+	defer func() { shuttingDown = false }()
+	Shutdown(0)
+
+	d := &KafkaDatasource{}
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := d.addStream("a", &kafka_client.KafkaClient{}, cancel); err != ErrShuttingDown {
+		t.Fatalf("expected the stream to be refused during the shutdown, got %v", err)
+	}
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	d := &KafkaDatasource{}
+	d.settings.MaxConcurrentStreams = 1
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := d.addStream("a", &kafka_client.KafkaClient{}, cancel); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.addStream("b", &kafka_client.KafkaClient{}, cancel); err == nil {
+		t.Fatal("expected the second stream to be refused")
+	}
+	d.removeStream("a")
+	if err := d.addStream("b", &kafka_client.KafkaClient{}, cancel); err != nil {
+		t.Fatalf("expected a slot after the first stream stopped, got %v", err)
+	}
+}
+
+func TestPublishStreamControl(t *testing.T) {
+	d := &KafkaDatasource{}
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := d.addStream("a", &kafka_client.KafkaClient{}, cancel); err != nil {
+		t.Fatal(err)
+	}
+	publish := func(path string, data string) (*backend.PublishStreamResponse, error) {
+		return d.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: path, Data: []byte(data)})
+	}
+
+	if resp, _ := publish("a", `{"action": "pause"}`); resp.Status != backend.PublishStreamStatusPermissionDenied {
+		t.Errorf("expected the control to be denied by default, got %v", resp.Status)
+	}
+
+	d.settings.AllowStreamControl = true
+	if resp, err := publish("a", `{"action": "pause"}`); err != nil || resp.Status != backend.PublishStreamStatusOK {
+		t.Fatalf("unexpected response %v, %v", resp, err)
+	}
+	if atomic.LoadInt32(d.pausedFlag("a")) != 1 {
+		t.Error("expected the stream to be paused")
+	}
+	if _, err := publish("a", `{"action": "resume"}`); err != nil || atomic.LoadInt32(d.pausedFlag("a")) != 0 {
+		t.Errorf("expected the stream to be resumed, got %v", err)
+	}
+	if _, err := publish("a", `{"action": "stop"}`); err == nil {
+		t.Error("expected an unknown action to fail")
+	}
+	if resp, _ := publish("b", `{"action": "pause"}`); resp.Status != backend.PublishStreamStatusNotFound {
+		t.Errorf("expected an unknown stream not to be found, got %v", resp.Status)
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	from := time.Date(2022, 1, 1, 6, 0, 0, 0, time.UTC)
+
+	if start := (queryModel{}).startTime(now); !start.IsZero() {
+		t.Errorf("expected no start time, got %v", start)
+	}
+	if start := (queryModel{StartRelative: "5m"}).startTime(now); !start.Equal(now.Add(-5 * time.Minute)) {
+		t.Errorf("unexpected relative start time %v", start)
+	}
+	qm := queryModel{StartRelative: "5m", UseTimeRange: true, TimeRangeFrom: from.UnixNano() / int64(time.Millisecond)}
+	if start := qm.startTime(now); !start.Equal(from) {
+		t.Errorf("expected the start of the time range, got %v", start)
+	}
+}
+
+func TestInTimeRange(t *testing.T) {
+	from := time.Date(2022, 1, 1, 6, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 1, 1, 7, 0, 0, 0, time.UTC)
+	qm := queryModel{UseTimeRange: true, TimeRangeTo: to.UnixNano() / int64(time.Millisecond)}
+
+	if end := qm.endTime(to.Add(time.Hour)); !end.Equal(to) {
+		t.Errorf("expected the end of the past time range, got %v", end)
+	}
+	if end := qm.endTime(to.Add(time.Second)); !end.IsZero() {
+		t.Errorf("expected no end for a time range ending now, got %v", end)
+	}
+	if end := (queryModel{TimeRangeTo: qm.TimeRangeTo}).endTime(to.Add(time.Hour)); !end.IsZero() {
+		t.Errorf("expected no end without the time range, got %v", end)
+	}
+
+	for _, test := range []struct {
+		time     time.Time
+		from, to time.Time
+		in       bool
+	}{
+		{from, from, to, true},
+		{to, from, to, true},
+		{from.Add(-time.Millisecond), from, to, false},
+		{to.Add(time.Millisecond), from, to, false},
+		{to.Add(time.Hour), from, time.Time{}, true},
+		{from.Add(-time.Hour), time.Time{}, time.Time{}, true},
+	} {
+		if in := inTimeRange(test.time, test.from, test.to); in != test.in {
+			t.Errorf("%v within %v and %v: expected %t", test.time, test.from, test.to, test.in)
+		}
+	}
+}
+
+func TestKeyMatcher(t *testing.T) {
+	if matches, err := (queryModel{}).keyMatcher(); matches != nil || err != nil {
+		t.Errorf("expected no filter, got %v", err)
+	}
+
+	prefix, _ := (queryModel{KeyFilter: "user-42"}).keyMatcher()
+	if !prefix([]byte("user-42")) || !prefix([]byte("user-421")) || prefix([]byte("a-user-42")) || prefix(nil) {
+		t.Errorf("expected the keys starting with the filter to match")
+	}
+
+	pattern, _ := (queryModel{KeyFilter: "^user-(42|43)$"}).keyMatcher()
+	if !pattern([]byte("user-43")) || pattern([]byte("user-421")) {
+		t.Errorf("expected the keys matching the pattern to match")
+	}
+
+	if err := (queryModel{Topic: "test", KeyFilter: "^user-("}).validate(); err == nil {
+		t.Errorf("expected an invalid pattern to fail")
+	}
+}
+
+func TestBatchDebounce(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	var pending batch
+
+	if pending.due(start) {
+		t.Error("expected an empty batch not to be due")
+	}
+	pending.add(start, frameRow{time: start})
+	pending.add(start.Add(STREAM_DEBOUNCE/2), frameRow{time: start})
+	if pending.due(start.Add(STREAM_DEBOUNCE / 2)) {
+		t.Error("expected the batch to wait for the debounce")
+	}
+	if !pending.due(start.Add(STREAM_DEBOUNCE)) {
+		t.Error("expected the batch to be due after the debounce")
+	}
+	if rows := pending.take(); len(rows) != 2 || pending.due(start.Add(STREAM_DEBOUNCE)) {
+		t.Errorf("expected the burst in a single frame, got %d rows", len(rows))
+	}
+}
+
+func TestSampler(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		rate         int64
+		maxPerSecond int64
+		// Arrival of the messages, in milliseconds after the start.
+		arrivals []int
+		kept     []bool
+	}{
+		{"no limit", 0, 0, []int{0, 1, 2}, []bool{true, true, true}},
+		{"one in three", 3, 0, []int{0, 1, 2, 3, 4, 5, 6}, []bool{true, false, false, true, false, false, true}},
+		{"per second", 0, 2, []int{0, 10, 20, 999, 1000, 1010, 1020}, []bool{true, true, false, false, true, true, false}},
+		{"both", 2, 1, []int{0, 1, 2, 3, 1000, 1001}, []bool{true, false, false, false, true, false}},
+	}
+
+	for _, test := range tests {
+		sampling := sampler{rate: test.rate, maxPerSecond: test.maxPerSecond}
+		for i, arrival := range test.arrivals {
+			if kept := sampling.keep(start.Add(time.Duration(arrival) * time.Millisecond)); kept != test.kept[i] {
+				t.Errorf("%s: expected message %d kept %v, got %v", test.name, i, test.kept[i], kept)
+			}
+		}
+	}
+}
+
+func TestDecodeErrorLimit(t *testing.T) {
+	bad := errors.New("invalid character")
+	tests := []struct {
+		name string
+		max  int64
+		// Decode errors of the messages, nil for the decoded ones.
+		results  []error
+		gaveUpAt int
+	}{
+		{"tolerated", 0, []error{bad, bad, bad, bad}, -1},
+		{"consecutive", 3, []error{bad, bad, bad}, 2},
+		{"reset by a decoded message", 3, []error{bad, bad, nil, bad, bad}, -1},
+		{"after a reset", 2, []error{bad, nil, bad, bad}, 3},
+		{"first message", 1, []error{bad}, 0},
+	}
+
+	for _, test := range tests {
+		limit := decodeErrorLimit{max: test.max}
+		gaveUpAt := -1
+		for i, err := range test.results {
+			if err := limit.record(err); err != nil {
+				if !errors.Is(err, bad) {
+					t.Errorf("%s: expected the decode error to be wrapped, got %v", test.name, err)
+				}
+				gaveUpAt = i
+				break
+			}
+		}
+		if gaveUpAt != test.gaveUpAt {
+			t.Errorf("%s: expected to give up at message %d, got %d", test.name, test.gaveUpAt, gaveUpAt)
+		}
+	}
+}
+
+func TestSchemaTracker(t *testing.T) {
+	status := streamStatus{Status: "streaming", Topic: "test"}
+	frame := func(offset string, fields ...string) *data.Frame {
+		frame := data.NewFrame("test", data.NewField("time", nil, []time.Time{}))
+		for _, field := range fields {
+			frame.Fields = append(frame.Fields, data.NewField(field, nil, []float64{}))
+		}
+		status.Offset = offset
+		return frame.SetMeta(&data.FrameMeta{Custom: status})
+	}
+	var schemas schemaTracker
+
+	if include := schemas.include(frame("1", "value")); include != data.IncludeAll {
+		t.Error("expected the first frame to include its schema")
+	}
+	if include := schemas.include(frame("2", "value")); include != data.IncludeDataOnly {
+		t.Error("expected a frame of the same schema to only include its data")
+	}
+	if include := schemas.include(frame("3", "value", "other")); include != data.IncludeAll {
+		t.Error("expected a frame with a new field to include its schema")
+	}
+	status.Status = "history"
+	if include := schemas.include(frame("4", "value", "other")); include != data.IncludeAll {
+		t.Error("expected a frame with a new status to include its schema")
+	}
+	if initial, err := schemas.initialData(); err != nil || initial == nil {
+		t.Errorf("expected the schema of the last frame as initial data, got %v", err)
+	}
+}
+
+func TestSchemaTrackerAlternatingFrames(t *testing.T) {
+	frame := func(name string) *data.Frame {
+		return data.NewFrame(name, data.NewField("value", nil, []float64{}))
+	}
+	schemas := &schemaTracker{}
+
+	if initial, err := schemas.initialData(); err != nil || initial != nil {
+		t.Error("expected no initial data before the first frame")
+	}
+	for i, test := range []struct {
+		name    string
+		include data.FrameInclude
+	}{
+		{"first", data.IncludeAll},
+		{"second", data.IncludeAll},
+		{"first", data.IncludeAll},
+		{"first", data.IncludeDataOnly},
+	} {
+		if include := schemas.include(frame(test.name)); include != test.include {
+			t.Errorf("frame %d of %s: expected %v, got %v", i, test.name, test.include, include)
+		}
+	}
+	if include := (*schemaTracker)(nil).include(frame("first")); include != data.IncludeAll {
+		t.Error("expected a nil tracker to include the schema")
+	}
+}
+
+func TestCollectMetrics(t *testing.T) {
+	streamReconnects.Inc()
+	d := &KafkaDatasource{}
+	var _ backend.CollectMetricsHandler = d
+
+	result, err := d.CollectMetrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(result.PrometheusMetrics), "grafana_kafka_datasource_reconnects_total") {
+		t.Errorf("expected the metrics of the streams, got %s", result.PrometheusMetrics)
+	}
+}
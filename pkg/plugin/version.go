@@ -0,0 +1,34 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const UNKNOWN_VERSION = "unknown"
+
+// pluginVersion reads the version of the plugin from the plugin.json shipped
+// next to the executable, since the build doesn't stamp it in the binary.
+func pluginVersion() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return UNKNOWN_VERSION
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(filepath.Dir(executable), "plugin.json"))
+	if err != nil {
+		return UNKNOWN_VERSION
+	}
+
+	var plugin struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(manifest, &plugin); err != nil || plugin.Info.Version == "" {
+		return UNKNOWN_VERSION
+	}
+
+	return plugin.Info.Version
+}
@@ -1,9 +1,9 @@
-import React, { ChangeEvent, PureComponent } from 'react';
+import React, { ChangeEvent, PureComponent, SyntheticEvent } from 'react';
 import { LegacyForms } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
 import { KafkaDataSourceOptions, KafkaSecureJsonData } from './types';
 
-const { SecretFormField, FormField } = LegacyForms;
+const { SecretFormField, FormField, Switch } = LegacyForms;
 
 interface Props extends DataSourcePluginOptionsEditorProps<KafkaDataSourceOptions> {}
 
@@ -51,126 +51,1132 @@ export class ConfigEditor extends PureComponent<Props, State> {
     onOptionsChange({
       ...options,
       secureJsonData: {
+        ...options.secureJsonData,
         saslPassword: event.target.value,
       },
     });
   };
 
-  onResetSaslPassword = () => {
-    const { onOptionsChange, options } = this.props;
-    onOptionsChange({
-      ...options,
-      secureJsonFields: {
-        ...options.secureJsonFields,
-        saslPassword: false,
-      },
-      secureJsonData: {
-        ...options.secureJsonData,
-        saslPassword: '',
-      },
-    });
-  };
+  onResetSaslPassword = () => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        saslPassword: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        saslPassword: '',
+      },
+    });
+  };
+
+  onApiKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      apiKey: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onApiSecretChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonData: {
+        ...options.secureJsonData,
+        apiSecret: event.target.value,
+      },
+    });
+  };
+
+  onResetApiSecret = () => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        apiSecret: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        apiSecret: '',
+      },
+    });
+  };
+
+  onSchemaRegistryPasswordChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonData: {
+        ...options.secureJsonData,
+        schemaRegistryPassword: event.target.value,
+      },
+    });
+  };
+
+  onResetSchemaRegistryPassword = () => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        schemaRegistryPassword: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        schemaRegistryPassword: '',
+      },
+    });
+  };
+
+  onDebugChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      debug: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onHealthcheckTimeoutChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      healthcheckTimeout: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSessionTimeoutMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      sessionTimeoutMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onHeartbeatIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      heartbeatIntervalMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onMaxPollIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      maxPollIntervalMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSaslKerberosServiceNameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      saslKerberosServiceName: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSaslKerberosPrincipalChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      saslKerberosPrincipal: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSaslKerberosKeytabChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      saslKerberosKeytab: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSaslKerberosKinitCmdChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      saslKerberosKinitCmd: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onDefaultTopicChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      defaultTopic: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onDefaultPartitionChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      defaultPartition: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onMaxMessageBytesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      maxMessageBytes: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onFetchWaitMaxMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      fetchWaitMaxMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onAutoOffsetResetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      autoOffsetReset: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onMaxReconnectAttemptsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      maxReconnectAttempts: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onBrokerAddressFamilyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      brokerAddressFamily: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onClientDnsLookupChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      clientDnsLookup: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onCharsetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      charset: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onInvalidCharsetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      invalidCharset: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onMetadataCacheTtlMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      metadataCacheTtlMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSchemaRegistryUrlChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      schemaRegistryUrl: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSchemaRegistryUsernameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      schemaRegistryUsername: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onEnableAutoCommitChange = (event?: SyntheticEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      enableAutoCommit: event?.currentTarget.checked || false,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onAutoCommitIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      autoCommitIntervalMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onDeepHealthCheckChange = (event?: SyntheticEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      deepHealthCheck: event?.currentTarget.checked || false,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onIsolationLevelChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      isolationLevel: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onPartitionAssignmentStrategyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      partitionAssignmentStrategy: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onTlsCaModeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      tlsCaMode: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onTlsCaCertChange = (event: ChangeEvent<HTMLTextAreaElement>) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonData: {
+        ...options.secureJsonData,
+        tlsCaCert: event.target.value,
+      },
+    });
+  };
+
+  onResetTlsCaCert = () => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({
+      ...options,
+      secureJsonFields: {
+        ...options.secureJsonFields,
+        tlsCaCert: false,
+      },
+      secureJsonData: {
+        ...options.secureJsonData,
+        tlsCaCert: '',
+      },
+    });
+  };
+
+  onMetadataTimeoutMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      metadataTimeoutMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onSocketTimeoutMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      socketTimeoutMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onAllowInternalTopicsChange = (event?: SyntheticEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      allowInternalTopics: event?.currentTarget.checked || false,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onQueuedMaxMessagesKbytesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      queuedMaxMessagesKbytes: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onQueuedMinMessagesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      queuedMinMessages: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onReconnectBackoffMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      reconnectBackoffMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onReconnectBackoffMaxMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      reconnectBackoffMaxMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onKeepaliveIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      keepaliveIntervalMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onMaxConcurrentStreamsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      maxConcurrentStreams: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onAllowedBootstrapServersChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      allowedBootstrapServers: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onAllowStreamControlChange = (event?: SyntheticEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      allowStreamControl: event?.currentTarget.checked || false,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onClientRackChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      clientRack: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onGroupInstanceIdChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      groupInstanceId: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  onStatisticsIntervalMsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onOptionsChange, options } = this.props;
+    const jsonData = {
+      ...options.jsonData,
+      statisticsIntervalMs: parseFloat(event.target.value),
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  render() {
+    const { options } = this.props;
+    const { jsonData, secureJsonFields } = options;
+    const secureJsonData = (options.secureJsonData || {}) as KafkaSecureJsonData;
+
+    return (
+      <div className="gf-form-group">
+        <div className="gf-form">
+          <FormField
+            label="Servers"
+            labelWidth={11}
+            onChange={this.onBootstrapServersChange}
+            value={jsonData.bootstrapServers || ''}
+            placeholder="broker1:9092, broker2:9092"
+            inputWidth={30}
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Security Protocol"
+            labelWidth={11}
+            onChange={this.onSecurityProtocolChange}
+            value={jsonData.securityProtocol || ''}
+            placeholder="<PLAINTEXT|SSL|SASL_PLAINTEXT|SASL_SSL>"
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="SASL Mechanisms"
+            labelWidth={11}
+            onChange={this.onSaslMechanismsChange}
+            value={jsonData.saslMechanisms || ''}
+            placeholder="<PLAIN|SCRAM-SHA-512|GSSAPI>"
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="SASL Username"
+            labelWidth={11}
+            onChange={this.onSaslUsernameChange}
+            value={jsonData.saslUsername || ''}
+            placeholder="<SASL Username>"
+          />
+        </div>
+
+        <div className="gf-form-inline">
+          <div className="gf-form">
+            <SecretFormField
+              isConfigured={(secureJsonFields && secureJsonFields.saslPassword) as boolean}
+              value={secureJsonData.saslPassword || ''}
+              label="SASL Password"
+              placeholder="<SASL Password>"
+              labelWidth={11}
+              inputWidth={20}
+              onReset={this.onResetSaslPassword}
+              onChange={this.onSaslPasswordChange}
+            />
+          </div>
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="API Key"
+            labelWidth={11}
+            onChange={this.onApiKeyChange}
+            value={jsonData.apiKey || ''}
+            placeholder="<Confluent Cloud API Key>"
+            tooltip="Used instead of the SASL settings, as the PLAIN username over SASL_SSL. Leave the SASL username and password empty."
+          />
+        </div>
+
+        <div className="gf-form-inline">
+          <div className="gf-form">
+            <SecretFormField
+              isConfigured={(secureJsonFields && secureJsonFields.apiSecret) as boolean}
+              value={secureJsonData.apiSecret || ''}
+              label="API Secret"
+              placeholder="<Confluent Cloud API Secret>"
+              labelWidth={11}
+              inputWidth={20}
+              onReset={this.onResetApiSecret}
+              onChange={this.onApiSecretChange}
+            />
+          </div>
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Debug"
+            labelWidth={11}
+            onChange={this.onDebugChange}
+            value={jsonData.debug || ''}
+            placeholder="<librdkafka Debug Level>"
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Healthcheck Timeout (ms)"
+            labelWidth={11}
+            onChange={this.onHealthcheckTimeoutChange}
+            value={jsonData.healthcheckTimeout || 2000}
+            type="number"
+            step="1"
+            min="0"
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Session Timeout (ms)"
+            labelWidth={11}
+            onChange={this.onSessionTimeoutMsChange}
+            value={jsonData.sessionTimeoutMs || ''}
+            placeholder="45000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Consumer group session timeout (session.timeout.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Heartbeat Interval (ms)"
+            labelWidth={11}
+            onChange={this.onHeartbeatIntervalMsChange}
+            value={jsonData.heartbeatIntervalMs || ''}
+            placeholder="3000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Consumer group heartbeat interval (heartbeat.interval.ms). Must be lower than a third of the session timeout."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Max Poll Interval (ms)"
+            labelWidth={11}
+            onChange={this.onMaxPollIntervalMsChange}
+            value={jsonData.maxPollIntervalMs || ''}
+            placeholder="300000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Longest time between two polls before a consumer leaves its group (max.poll.interval.ms); raise it when slow panels back up the streams. Must not be lower than the session timeout."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Kerberos Service"
+            labelWidth={11}
+            onChange={this.onSaslKerberosServiceNameChange}
+            value={jsonData.saslKerberosServiceName || ''}
+            placeholder="kafka"
+            tooltip="Kerberos principal name Kafka runs as (sasl.kerberos.service.name), used with the GSSAPI mechanism."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Kerberos Principal"
+            labelWidth={11}
+            onChange={this.onSaslKerberosPrincipalChange}
+            value={jsonData.saslKerberosPrincipal || ''}
+            placeholder="<client principal>"
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Kerberos Keytab"
+            labelWidth={11}
+            onChange={this.onSaslKerberosKeytabChange}
+            value={jsonData.saslKerberosKeytab || ''}
+            placeholder="/etc/security/keytabs/grafana.keytab"
+            tooltip="Path to the keytab file, which must be readable by the Grafana server."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Kerberos kinit"
+            labelWidth={11}
+            onChange={this.onSaslKerberosKinitCmdChange}
+            value={jsonData.saslKerberosKinitCmd || ''}
+            placeholder="<kinit command>"
+            tooltip="Shell command used to refresh the Kerberos ticket (sasl.kerberos.kinit.cmd)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Default Topic"
+            labelWidth={11}
+            onChange={this.onDefaultTopicChange}
+            value={jsonData.defaultTopic || ''}
+            placeholder="<topic>"
+            tooltip="Topic used by the queries which don't set one."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Default Partition"
+            labelWidth={11}
+            onChange={this.onDefaultPartitionChange}
+            value={jsonData.defaultPartition ?? ''}
+            placeholder="0"
+            type="number"
+            step="1"
+            min="-1"
+            tooltip="Partition used along with the default topic; -1 consumes all the partitions."
+          />
+        </div>
 
-  onDebugChange = (event: ChangeEvent<HTMLInputElement>) => {
-    const { onOptionsChange, options } = this.props;
-    const jsonData = {
-      ...options.jsonData,
-      debug: event.target.value,
-    };
-    onOptionsChange({ ...options, jsonData });
-  };
+        <div className="gf-form">
+          <FormField
+            label="Max Message Bytes"
+            labelWidth={11}
+            onChange={this.onMaxMessageBytesChange}
+            value={jsonData.maxMessageBytes || ''}
+            placeholder="1048576"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Maximum size of the messages to fetch (fetch.message.max.bytes); raise it for topics with messages of several megabytes."
+          />
+        </div>
 
-  onHealthcheckTimeoutChange = (event: ChangeEvent<HTMLInputElement>) => {
-    const { onOptionsChange, options } = this.props;
-    const jsonData = {
-      ...options.jsonData,
-      healthcheckTimeout: parseFloat(event.target.value),
-    };
-    onOptionsChange({ ...options, jsonData });
-  };
+        <div className="gf-form">
+          <FormField
+            label="Fetch Wait Max"
+            labelWidth={11}
+            onChange={this.onFetchWaitMaxMsChange}
+            value={jsonData.fetchWaitMaxMs || ''}
+            placeholder="500"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds the brokers wait for messages before answering a fetch (fetch.wait.max.ms); lower it to show the messages of low-rate topics sooner."
+          />
+        </div>
 
-  render() {
-    const { options } = this.props;
-    const { jsonData, secureJsonFields } = options;
-    const secureJsonData = (options.secureJsonData || {}) as KafkaSecureJsonData;
+        <div className="gf-form">
+          <FormField
+            label="Auto Offset Reset"
+            labelWidth={11}
+            onChange={this.onAutoOffsetResetChange}
+            value={jsonData.autoOffsetReset || ''}
+            placeholder="latest"
+            tooltip="Default of the queries which don't set an auto offset reset: latest, earliest (last 100 messages) or error (the committed offset, failing when there is none)."
+          />
+        </div>
 
-    return (
-      <div className="gf-form-group">
         <div className="gf-form">
           <FormField
-            label="Servers"
+            label="Max Reconnects"
             labelWidth={11}
-            onChange={this.onBootstrapServersChange}
-            value={jsonData.bootstrapServers || ''}
-            placeholder="broker1:9092, broker2:9092"
-            inputWidth={30}
+            onChange={this.onMaxReconnectAttemptsChange}
+            value={jsonData.maxReconnectAttempts || ''}
+            placeholder="0"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Consecutive reconnections before a stream gives up and reports a failure; 0 retries forever."
           />
         </div>
 
         <div className="gf-form">
           <FormField
-            label="Security Protocol"
+            label="Address Family"
             labelWidth={11}
-            onChange={this.onSecurityProtocolChange}
-            value={jsonData.securityProtocol || ''}
-            placeholder="<PLAINTEXT|SASL_SSL>"
+            onChange={this.onBrokerAddressFamilyChange}
+            value={jsonData.brokerAddressFamily || ''}
+            placeholder="any"
+            tooltip="Address family used to reach the brokers (broker.address.family): any, v4 or v6."
           />
         </div>
 
         <div className="gf-form">
           <FormField
-            label="SASL Mechanisms"
+            label="DNS Lookup"
             labelWidth={11}
-            onChange={this.onSaslMechanismsChange}
-            value={jsonData.saslMechanisms || ''}
-            placeholder="<PLAIN|SCRAM-SHA-512>"
+            onChange={this.onClientDnsLookupChange}
+            value={jsonData.clientDnsLookup || ''}
+            placeholder="use_all_dns_ips"
+            tooltip="How the broker host names are resolved (client.dns.lookup): use_all_dns_ips or resolve_canonical_bootstrap_servers_only."
           />
         </div>
 
         <div className="gf-form">
           <FormField
-            label="SASL Username"
+            label="Charset"
             labelWidth={11}
-            onChange={this.onSaslUsernameChange}
-            value={jsonData.saslUsername || ''}
-            placeholder="<SASL Username>"
+            onChange={this.onCharsetChange}
+            value={jsonData.charset || ''}
+            placeholder="utf-8"
+            tooltip="Charset of the message values, e.g. iso-8859-1 or windows-1252; they are converted to UTF-8 before decoding."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Invalid Bytes"
+            labelWidth={11}
+            onChange={this.onInvalidCharsetChange}
+            value={jsonData.invalidCharset || ''}
+            placeholder="replace"
+            tooltip="What to do with the bytes invalid in the charset: replace them with U+FFFD, skip them, or error to drop the message."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Metadata Cache TTL"
+            labelWidth={11}
+            onChange={this.onMetadataCacheTtlMsChange}
+            value={jsonData.metadataCacheTtlMs || ''}
+            placeholder="5000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds the broker metadata is cached for, so that repeated editor requests don't hammer the brokers."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Schema Registry"
+            labelWidth={11}
+            onChange={this.onSchemaRegistryUrlChange}
+            value={jsonData.schemaRegistryUrl || ''}
+            placeholder="http://schema-registry:8081"
+            tooltip="URL of the schema registry used by the Protobuf (Schema Registry) format."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Registry Username"
+            labelWidth={11}
+            onChange={this.onSchemaRegistryUsernameChange}
+            value={jsonData.schemaRegistryUsername || ''}
+            placeholder="<Registry Username>"
           />
         </div>
 
         <div className="gf-form-inline">
           <div className="gf-form">
             <SecretFormField
-              isConfigured={(secureJsonFields && secureJsonFields.saslPassword) as boolean}
-              value={secureJsonData.saslPassword || ''}
-              label="SASL Password"
-              placeholder="<SASL Password>"
+              isConfigured={(secureJsonFields && secureJsonFields.schemaRegistryPassword) as boolean}
+              value={secureJsonData.schemaRegistryPassword || ''}
+              label="Registry Password"
+              placeholder="<Registry Password>"
               labelWidth={11}
               inputWidth={20}
-              onReset={this.onResetSaslPassword}
-              onChange={this.onSaslPasswordChange}
+              onReset={this.onResetSchemaRegistryPassword}
+              onChange={this.onSchemaRegistryPasswordChange}
             />
           </div>
         </div>
 
+        <div className="gf-form">
+          <Switch
+            label="Auto Commit"
+            labelClass="width-11"
+            checked={jsonData.enableAutoCommit || false}
+            onChange={this.onEnableAutoCommitChange}
+            tooltip="Let the consumers commit their offsets automatically (enable.auto.commit)."
+          />
+        </div>
+
         <div className="gf-form">
           <FormField
-            label="Debug"
+            label="Commit Interval"
             labelWidth={11}
-            onChange={this.onDebugChange}
-            value={jsonData.debug || ''}
-            placeholder="<librdkafka Debug Level>"
+            onChange={this.onAutoCommitIntervalMsChange}
+            value={jsonData.autoCommitIntervalMs || ''}
+            placeholder="5000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds between the automatic offset commits (auto.commit.interval.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <Switch
+            label="Deep Health Check"
+            labelClass="width-11"
+            checked={jsonData.deepHealthCheck || false}
+            onChange={this.onDeepHealthCheckChange}
+            tooltip="Also produce a message to the _grafana_healthcheck topic and consume it back when testing the data source; requires the produce ACLs."
           />
         </div>
 
         <div className="gf-form">
           <FormField
-            label="Healthcheck Timeout (ms)"
+            label="Isolation Level"
             labelWidth={11}
-            onChange={this.onHealthcheckTimeoutChange}
-            value={jsonData.healthcheckTimeout || 2000}
+            onChange={this.onIsolationLevelChange}
+            value={jsonData.isolationLevel || ''}
+            placeholder="read_committed"
+            tooltip="read_committed hides the records of aborted transactions, read_uncommitted shows every record (isolation.level)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Assignment Strategy"
+            labelWidth={11}
+            onChange={this.onPartitionAssignmentStrategyChange}
+            value={jsonData.partitionAssignmentStrategy || ''}
+            placeholder="range,roundrobin"
+            tooltip="Assignment of the partitions subscribed to by the consumer groups: range, roundrobin or cooperative-sticky, which avoids the rebalance storms of panels joining and leaving."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="TLS CA"
+            labelWidth={11}
+            onChange={this.onTlsCaModeChange}
+            value={jsonData.tlsCaMode || ''}
+            placeholder="system"
+            tooltip="CA verifying the brokers of the SSL and SASL_SSL protocols: system uses the trust store of the OS, e.g. for Confluent Cloud, provided uses the CA certificate below."
+          />
+        </div>
+
+        {jsonData.tlsCaMode === 'provided' && (
+          <div className="gf-form-inline">
+            <div className="gf-form">
+              {secureJsonFields && secureJsonFields.tlsCaCert ? (
+                <SecretFormField
+                  isConfigured={true}
+                  value=""
+                  label="CA Certificate"
+                  labelWidth={11}
+                  inputWidth={20}
+                  onReset={this.onResetTlsCaCert}
+                  onChange={() => {}}
+                />
+              ) : (
+                <>
+                  <span className="gf-form-label width-11">CA Certificate</span>
+                  <textarea
+                    className="gf-form-input width-30"
+                    rows={7}
+                    value={secureJsonData.tlsCaCert || ''}
+                    placeholder="-----BEGIN CERTIFICATE-----"
+                    onChange={this.onTlsCaCertChange}
+                  />
+                </>
+              )}
+            </div>
+          </div>
+        )}
+
+        <div className="gf-form">
+          <FormField
+            label="Metadata Timeout"
+            labelWidth={11}
+            onChange={this.onMetadataTimeoutMsChange}
+            value={jsonData.metadataTimeoutMs || ''}
+            placeholder="2000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds to wait for the metadata and offsets requests, e.g. of the health check; raise it for distant clusters (metadata.request.timeout.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Socket Timeout"
+            labelWidth={11}
+            onChange={this.onSocketTimeoutMsChange}
+            value={jsonData.socketTimeoutMs || ''}
+            placeholder="60000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds to wait for the requests to the brokers (socket.timeout.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <Switch
+            label="Internal Topics"
+            labelClass="width-11"
+            checked={jsonData.allowInternalTopics || false}
+            onChange={this.onAllowInternalTopicsChange}
+            tooltip="Allow reading the messages of the internal topics, like __consumer_offsets; their binary records are best read with the base64 or hex format."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Max Queued KB"
+            labelWidth={11}
+            onChange={this.onQueuedMaxMessagesKbytesChange}
+            value={jsonData.queuedMaxMessagesKbytes || ''}
+            placeholder="65536"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Maximum kilobytes of messages prefetched by every consumer; lower it to bound the memory of many concurrent panels (queued.max.messages.kbytes)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Min Queued Messages"
+            labelWidth={11}
+            onChange={this.onQueuedMinMessagesChange}
+            value={jsonData.queuedMinMessages || ''}
+            placeholder="100000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Number of messages every consumer tries to keep prefetched (queued.min.messages)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Reconnect Backoff"
+            labelWidth={11}
+            onChange={this.onReconnectBackoffMsChange}
+            value={jsonData.reconnectBackoffMs || ''}
+            placeholder="100"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds before reconnecting to a broker, doubled after every failed attempt (reconnect.backoff.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Max Backoff"
+            labelWidth={11}
+            onChange={this.onReconnectBackoffMaxMsChange}
+            value={jsonData.reconnectBackoffMaxMs || ''}
+            placeholder="10000"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Maximum milliseconds between the reconnections to a broker (reconnect.backoff.max.ms)."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Keepalive Interval"
+            labelWidth={11}
+            onChange={this.onKeepaliveIntervalMsChange}
+            value={jsonData.keepaliveIntervalMs || ''}
+            placeholder="0"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Milliseconds after which an idle stream sends an empty frame, keeping the proxies from dropping the connection of the panels; 0 disables it."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Max Streams"
+            labelWidth={11}
+            onChange={this.onMaxConcurrentStreamsChange}
+            value={jsonData.maxConcurrentStreams || ''}
+            placeholder="0"
+            type="number"
+            step="1"
+            min="0"
+            tooltip="Streams started beyond that many fail with an error, 0 allows any number."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Allowed Servers"
+            labelWidth={11}
+            onChange={this.onAllowedBootstrapServersChange}
+            value={jsonData.allowedBootstrapServers || ''}
+            placeholder="<broker1:9092,broker2:9092>"
+            tooltip="Servers of other clusters the queries may read from, with the credentials of this data source."
+          />
+        </div>
+
+        <div className="gf-form">
+          <Switch
+            label="Stream Control"
+            labelClass="width-11"
+            checked={jsonData.allowStreamControl || false}
+            onChange={this.onAllowStreamControlChange}
+            tooltip="Lets the users pause and resume the streams by publishing to their channel."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Client Rack"
+            labelWidth={11}
+            onChange={this.onClientRackChange}
+            value={jsonData.clientRack || ''}
+            placeholder="<eu-west-1a>"
+            tooltip="Rack, or availability zone, of Grafana. The consumers fetch from the replicas of the same rack when the brokers set replica.selector.class to the RackAwareReplicaSelector."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Group Instance ID"
+            labelWidth={11}
+            onChange={this.onGroupInstanceIdChange}
+            value={jsonData.groupInstanceId || ''}
+            placeholder="<grafana>"
+            tooltip="Static membership of the consumer group subscriptions, suffixed with the host name of each Grafana replica and the id of each stream, so that a restarting replica doesn't trigger a rebalance."
+          />
+        </div>
+
+        <div className="gf-form">
+          <FormField
+            label="Statistics Interval"
+            labelWidth={11}
+            onChange={this.onStatisticsIntervalMsChange}
+            value={jsonData.statisticsIntervalMs || ''}
+            placeholder="0"
             type="number"
             step="1"
             min="0"
+            tooltip="Interval in milliseconds of the librdkafka statistics of the stream consumers, exported as metrics; 0 disables them."
           />
         </div>
       </div>
@@ -3,7 +3,18 @@ import React, { ChangeEvent, PureComponent, SyntheticEvent } from 'react';
 import { InlineFormLabel, InlineFieldRow, Select, Switch } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './datasource';
-import { defaultQuery, KafkaDataSourceOptions, KafkaQuery, AutoOffsetReset, TimestampMode } from './types';
+import {
+  defaultQuery,
+  KafkaDataSourceOptions,
+  KafkaQuery,
+  AutoOffsetReset,
+  TimestampMode,
+  MessageFormat,
+  Aggregation,
+  QueryMode,
+  SortBy,
+  FrameMode,
+} from './types';
 
 const autoResetOffsets = [
   {
@@ -16,8 +27,20 @@ const autoResetOffsets = [
     value: AutoOffsetReset.LATEST,
     description: 'Consume from the latest offset',
   },
+  {
+    label: 'Committed or error',
+    value: AutoOffsetReset.ERROR,
+    description: 'Consume from the committed offset, failing when there is none',
+  },
 ] as Array<SelectableValue<AutoOffsetReset>>;
 
+const queryModes = [
+  { label: 'Messages', value: QueryMode.Messages, description: 'Values of the messages of the topic' },
+  { label: 'Offsets', value: QueryMode.Offsets, description: 'Low and high watermark offsets of every partition' },
+  { label: 'Snapshot', value: QueryMode.Snapshot, description: 'Every message of the partitions, read once up to their end' },
+  { label: 'History', value: QueryMode.History, description: 'Every message of the partitions, then the new ones live' },
+] as Array<SelectableValue<QueryMode>>;
+
 const timestampModes = [
   {
     label: 'Now',
@@ -31,8 +54,122 @@ const timestampModes = [
   },
 ] as Array<SelectableValue<TimestampMode>>;
 
+const messageFormats = [
+  {
+    label: 'JSON',
+    value: MessageFormat.JSON,
+    description: 'JSON objects, nested objects are flattened',
+  },
+  {
+    label: 'JSON array',
+    value: MessageFormat.JSONArray,
+    description: 'A JSON array of objects, each one being a row',
+  },
+  {
+    label: 'JSON lines',
+    value: MessageFormat.NDJSON,
+    description: 'A JSON object per line, each one being a row',
+  },
+  {
+    label: 'CSV',
+    value: MessageFormat.CSV,
+    description: 'A row of delimiter-separated values',
+  },
+  {
+    label: 'Protobuf (Schema Registry)',
+    value: MessageFormat.ProtobufSR,
+    description: 'Protobuf messages of the Confluent serializer, decoded with the schema registry',
+  },
+  {
+    label: 'Base64',
+    value: MessageFormat.Base64,
+    description: 'The raw bytes, base64 encoded into a value field',
+  },
+  {
+    label: 'Hex',
+    value: MessageFormat.Hex,
+    description: 'The raw bytes, hex encoded into a value field',
+  },
+  {
+    label: 'String',
+    value: MessageFormat.String,
+    description: 'The text, as is in a value field',
+  },
+  {
+    label: 'MessagePack',
+    value: MessageFormat.MsgPack,
+    description: 'MessagePack maps, decoded like JSON objects',
+  },
+  {
+    label: 'XML',
+    value: MessageFormat.XML,
+    description: 'XML documents, the children and attributes of the root element as fields',
+  },
+] as Array<SelectableValue<MessageFormat>>;
+
+const aggregations = [
+  { label: 'None', value: Aggregation.None, description: 'Show every message' },
+  { label: 'Count', value: Aggregation.Count, description: 'Number of messages per window' },
+  { label: 'Sum', value: Aggregation.Sum, description: 'Sum of each field per window' },
+  { label: 'Average', value: Aggregation.Avg, description: 'Average of each field per window' },
+  { label: 'Min', value: Aggregation.Min, description: 'Minimum of each field per window' },
+  { label: 'Max', value: Aggregation.Max, description: 'Maximum of each field per window' },
+] as Array<SelectableValue<Aggregation>>;
+
+const frameModes = [
+  { label: 'Merged', value: FrameMode.Merged, description: 'A frame holding the messages of all the topics' },
+  { label: 'Per topic', value: FrameMode.PerTopic, description: 'A frame per topic, named after it' },
+] as Array<SelectableValue<FrameMode>>;
+
+const sortOrders = [
+  { label: 'Time', value: SortBy.Time, description: 'Rows sorted by time' },
+  { label: 'Offset', value: SortBy.Offset, description: 'Rows sorted by partition and offset' },
+  { label: 'None', value: SortBy.None, description: 'Rows in the arrival order of the messages' },
+] as Array<SelectableValue<SortBy>>;
+
 type Props = QueryEditorProps<DataSource, KafkaQuery, KafkaDataSourceOptions>;
 
+const parseOffset = (value: string) => (value === '' ? undefined : parseInt(value, 10));
+
+const splitPatterns = (value: string) =>
+  value
+    .split(',')
+    .map((pattern) => pattern.trim())
+    .filter((pattern) => pattern !== '');
+
+// parseAliases reads comma-separated name=alias pairs.
+const parseAliases = (value: string) =>
+  value.split(',').reduce((aliases, pair) => {
+    const [name, ...alias] = pair.split('=');
+    if (name.trim() !== '' && alias.join('=').trim() !== '') {
+      aliases[name.trim()] = alias.join('=').trim();
+    }
+    return aliases;
+  }, {} as Record<string, string>);
+
+const formatAliases = (aliases: Record<string, string> = {}) =>
+  Object.keys(aliases)
+    .map((name) => `${name}=${aliases[name]}`)
+    .join(', ');
+
+// parseBounds reads comma-separated name=number pairs, dropping the invalid
+// numbers.
+const parseBounds = (value: string) => {
+  const bounds = parseAliases(value);
+  return Object.keys(bounds).reduce((numbers, name) => {
+    const bound = parseFloat(bounds[name]);
+    if (!isNaN(bound)) {
+      numbers[name] = bound;
+    }
+    return numbers;
+  }, {} as Record<string, number>);
+};
+
+const formatBounds = (bounds: Record<string, number> = {}) =>
+  Object.keys(bounds)
+    .map((name) => `${name}=${bounds[name]}`)
+    .join(', ');
+
 export class QueryEditor extends PureComponent<Props> {
   onTopicNameChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query, onRunQuery } = this.props;
@@ -52,9 +189,15 @@ export class QueryEditor extends PureComponent<Props> {
     onRunQuery();
   };
 
+  onModeChanged = (selected: SelectableValue<QueryMode>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, mode: selected.value || QueryMode.Messages });
+    onRunQuery();
+  };
+
   onAutoResetOffsetChanged = (selected: SelectableValue<AutoOffsetReset>) => {
     const { onChange, query, onRunQuery } = this.props;
-    onChange({ ...query, autoOffsetReset: selected.value || AutoOffsetReset.LATEST });
+    onChange({ ...query, autoOffsetReset: selected?.value });
     onRunQuery();
   };
 
@@ -62,7 +205,13 @@ export class QueryEditor extends PureComponent<Props> {
     if (value === AutoOffsetReset.LATEST) {
       return autoResetOffsets[1];
     }
-    return autoResetOffsets[0];
+    if (value === AutoOffsetReset.EARLIEST) {
+      return autoResetOffsets[0];
+    }
+    if (value === AutoOffsetReset.ERROR) {
+      return autoResetOffsets[2];
+    }
+    return null;
   };
 
   onTimestampModeChanged = (selected: SelectableValue<TimestampMode>) => {
@@ -78,9 +227,292 @@ export class QueryEditor extends PureComponent<Props> {
     return timestampModes[1];
   };
 
+  onPrefetchLastChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, prefetchLast: parseInt(event.target.value, 10) || 0 });
+    onRunQuery();
+  };
+
+  onIncludeFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, includeFields: splitPatterns(event.target.value) });
+  };
+
+  onTimestampFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timestampFields: splitPatterns(event.target.value) });
+  };
+
+  onExcludeFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, excludeFields: splitPatterns(event.target.value) });
+  };
+
+  onFormatChanged = (selected: SelectableValue<MessageFormat>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, format: selected.value || MessageFormat.JSON });
+    onRunQuery();
+  };
+
+  onCsvHeaderChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, csvHeader: event.target.value });
+  };
+
+  onCsvDelimiterChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, csvDelimiter: event.target.value });
+  };
+
+  onSampleRateChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, sampleRate: parseInt(event.target.value, 10) || 0 });
+    onRunQuery();
+  };
+
+  onMaxMessagesPerSecondChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, maxMessagesPerSecond: parseInt(event.target.value, 10) || 0 });
+    onRunQuery();
+  };
+
+  onAggregationChanged = (selected: SelectableValue<Aggregation>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, aggregation: selected.value || Aggregation.None });
+    onRunQuery();
+  };
+
+  onAggregationWindowChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, aggregationWindow: event.target.value });
+  };
+
+  onFromOffsetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, fromOffset: parseOffset(event.target.value) });
+  };
+
+  onToOffsetChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, toOffset: parseOffset(event.target.value) });
+  };
+
+  onSeriesTimeFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, seriesTimeField: event.target.value });
+  };
+
+  onSeriesValueFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, seriesValueField: event.target.value });
+  };
+
+  onMaxFieldsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, maxFields: parseInt(event.target.value, 10) || 0 });
+  };
+
+  onSkipTombstonesChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, skipTombstones: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onFieldAliasesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, fieldAliases: parseAliases(event.target.value) });
+  };
+
+  onClampMinChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, clampMin: parseBounds(event.target.value) });
+  };
+
+  onClampMaxChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, clampMax: parseBounds(event.target.value) });
+  };
+
+  onTimeFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timeField: event.target.value });
+  };
+
+  onTimeFieldFormatChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timeFieldFormat: event.target.value });
+  };
+
+  onFrameModeChanged = (selected: SelectableValue<FrameMode>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, frameMode: selected.value || FrameMode.Merged });
+    onRunQuery();
+  };
+
+  onSortByChanged = (selected: SelectableValue<SortBy>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, sortBy: selected.value || SortBy.Time });
+    onRunQuery();
+  };
+
+  onNameFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, nameField: event.target.value });
+  };
+
+  onValueFieldChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, valueField: event.target.value });
+  };
+
+  onIncludeMetadataChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, includeMetadata: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onFromKeyChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, fromKey: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onStripSchemaPrefixChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, stripSchemaPrefix: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onStartRelativeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, startRelative: event.target.value });
+  };
+
+  onUseTimeRangeChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, useTimeRange: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onMaxConsecutiveDecodeErrorsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, maxConsecutiveDecodeErrors: parseInt(event.target.value, 10) || 0 });
+  };
+
+  onIncludeRawChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, includeRaw: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onBootstrapServersChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, bootstrapServers: event.target.value });
+  };
+
+  onScalarFieldNameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, scalarFieldName: event.target.value });
+  };
+
+  onFrameNameChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, frameName: event.target.value });
+  };
+
+  onMaxStringLengthChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, maxStringLength: parseInt(event.target.value, 10) || 0 });
+  };
+
+  onParallelPartitionsChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, parallelPartitions: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onSuppressInitialFrameChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, suppressInitialFrame: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onKeyFilterChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, keyFilter: event.target.value });
+  };
+
+  onIncludeSeqChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, includeSeq: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onStrictTimeRangeChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, strictTimeRange: event.currentTarget.checked });
+    onRunQuery();
+  };
+
+  onEventModeChange = (event: SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, eventMode: event.currentTarget.checked });
+    onRunQuery();
+  };
+
   render() {
     const query = defaults(this.props.query, defaultQuery);
-    const { topicName, partition, withStreaming, autoOffsetReset, timestampMode } = query;
+    const {
+      topicName,
+      partition,
+      withStreaming,
+      autoOffsetReset,
+      timestampMode,
+      prefetchLast,
+      includeFields,
+      excludeFields,
+      timestampFields,
+      format,
+      csvHeader,
+      csvDelimiter,
+      sampleRate,
+      maxMessagesPerSecond,
+      aggregation,
+      aggregationWindow,
+      sortBy,
+      frameMode,
+      fromOffset,
+      toOffset,
+      mode,
+      seriesTimeField,
+      seriesValueField,
+      maxFields,
+      skipTombstones,
+      fieldAliases,
+      clampMin,
+      clampMax,
+      timeField,
+      timeFieldFormat,
+      nameField,
+      valueField,
+      includeMetadata,
+      fromKey,
+      stripSchemaPrefix,
+      startRelative,
+      useTimeRange,
+      maxConsecutiveDecodeErrors,
+      includeRaw,
+      bootstrapServers,
+      scalarFieldName,
+      frameName,
+      maxStringLength,
+      parallelPartitions,
+      suppressInitialFrame,
+      keyFilter,
+      includeSeq,
+      strictTimeRange,
+      eventMode,
+    } = query;
 
     return (
       <>
@@ -91,16 +523,19 @@ export class QueryEditor extends PureComponent<Props> {
               className="gf-form-input width-14"
               value={topicName || ''}
               onChange={this.onTopicNameChange}
+              placeholder={this.props.datasource.defaultTopic}
               type="text"
             />
-            <InlineFormLabel width={10}>Partition</InlineFormLabel>
+            <InlineFormLabel width={10} tooltip="Partition to consume, or -1 to consume all the partitions of the topic.">
+              Partition
+            </InlineFormLabel>
             <input
               className="gf-form-input width-14"
               value={partition}
               onChange={this.onPartitionChange}
               type="number"
               step="1"
-              min="0"
+              min="-1"
             />
             <InlineFormLabel>
               Enable streaming <small>(v8+)</small>
@@ -110,11 +545,26 @@ export class QueryEditor extends PureComponent<Props> {
             </div>
           </InlineFieldRow>
         </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Whether to query the messages or the watermark offsets of the topic.">
+              Mode
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={queryModes.find((option) => option.value === mode) || queryModes[0]}
+                options={queryModes}
+                onChange={this.onModeChanged}
+              />
+            </div>
+          </InlineFieldRow>
+        </div>
         <div className="gf-form">
           <InlineFieldRow>
             <InlineFormLabel
               className="width-5"
-              tooltip="Starting offset to consume that can be from latest or last 100."
+              tooltip="Starting offset to consume that can be from latest or last 100. Defaults to the datasource setting."
             >
               Auto offset reset
             </InlineFormLabel>
@@ -123,7 +573,8 @@ export class QueryEditor extends PureComponent<Props> {
                 className="width-14"
                 value={this.resolveAutoResetOffset(autoOffsetReset)}
                 options={autoResetOffsets}
-                defaultValue={autoResetOffsets[0]}
+                placeholder="Datasource default"
+                isClearable
                 onChange={this.onAutoResetOffsetChanged}
               />
             </div>
@@ -139,6 +590,643 @@ export class QueryEditor extends PureComponent<Props> {
             </div>
           </InlineFieldRow>
         </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Number of messages before the latest offset to replay when the stream starts."
+            >
+              Prefetch last
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={prefetchLast}
+              onChange={this.onPrefetchLastChange}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Comma-separated glob patterns of the fields to keep, e.g. metrics.*">
+              Include fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              defaultValue={(includeFields || []).join(', ')}
+              onChange={this.onIncludeFieldsChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+            <InlineFormLabel width={10} tooltip="Comma-separated glob patterns of the fields to drop.">
+              Exclude fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              defaultValue={(excludeFields || []).join(', ')}
+              onChange={this.onExcludeFieldsChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Comma-separated fields holding ISO 8601 timestamps, e.g. createdAt, shown as times instead of strings."
+            >
+              Timestamp fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              defaultValue={(timestampFields || []).join(', ')}
+              onChange={this.onTimestampFieldsChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Format of the message values.">
+              Format
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={messageFormats.find((option) => option.value === format) || messageFormats[0]}
+                options={messageFormats}
+                onChange={this.onFormatChanged}
+              />
+            </div>
+            {format === MessageFormat.CSV && (
+              <>
+                <InlineFormLabel width={10} tooltip="Names of the columns, separated by the delimiter.">
+                  CSV header
+                </InlineFormLabel>
+                <input
+                  className="gf-form-input width-14"
+                  value={csvHeader || ''}
+                  onChange={this.onCsvHeaderChange}
+                  onBlur={this.props.onRunQuery}
+                  type="text"
+                />
+                <InlineFormLabel width={6} tooltip="Single character, or \t for tabs.">
+                  Delimiter
+                </InlineFormLabel>
+                <input
+                  className="gf-form-input width-4"
+                  value={csvDelimiter || ''}
+                  placeholder=","
+                  onChange={this.onCsvDelimiterChange}
+                  onBlur={this.props.onRunQuery}
+                  type="text"
+                />
+              </>
+            )}
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Keep one message in N; 0 or 1 keeps every message.">
+              Sample 1 in
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={sampleRate || ''}
+              placeholder="1"
+              onChange={this.onSampleRateChange}
+              type="number"
+              step="1"
+              min="0"
+            />
+            <InlineFormLabel width={10} tooltip="Messages beyond this rate are dropped; 0 disables the limit.">
+              Max messages/s
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxMessagesPerSecond || ''}
+              placeholder="unlimited"
+              onChange={this.onMaxMessagesPerSecondChange}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Aggregate the messages of every window into a single row.">
+              Aggregation
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={aggregations.find((option) => option.value === (aggregation || Aggregation.None))}
+                options={aggregations}
+                onChange={this.onAggregationChanged}
+              />
+            </div>
+            <InlineFormLabel width={10} tooltip="Length of the tumbling window, e.g. 1s, 10s or 1m.">
+              Window
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={aggregationWindow || ''}
+              placeholder="1s"
+              onChange={this.onAggregationWindowChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Set both offsets to replay this range of the selected partition instead of streaming."
+            >
+              From offset
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={fromOffset ?? ''}
+              onChange={this.onFromOffsetChange}
+              onBlur={this.props.onRunQuery}
+              type="number"
+              step="1"
+              min="0"
+            />
+            <InlineFormLabel width={10}>To offset</InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={toOffset ?? ''}
+              onChange={this.onToOffsetChange}
+              onBlur={this.props.onRunQuery}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Array field holding the times of a time series packed in the message, read with the time format."
+            >
+              Series times
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={seriesTimeField || ''}
+              onChange={this.onSeriesTimeFieldChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+            <InlineFormLabel
+              width={10}
+              tooltip="Array field holding the values of the series; each point becomes a row."
+            >
+              Series values
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={seriesValueField || ''}
+              onChange={this.onSeriesValueFieldChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Maximum number of distinct fields, 100 by default; the fields beyond it are counted in an __overflow field."
+            >
+              Max fields
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxFields || ''}
+              onChange={this.onMaxFieldsChange}
+              onBlur={this.props.onRunQuery}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Leave out the null valued messages of compacted topics, shown as rows with a __tombstone field otherwise."
+            >
+              Skip tombstones
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={skipTombstones || false} onChange={this.onSkipTombstonesChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel width={10} tooltip="Comma-separated name=alias pairs renaming the fields, e.g. v1=Latency (ms)">
+              Field aliases
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-30"
+              defaultValue={formatAliases(fieldAliases)}
+              onChange={this.onFieldAliasesChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Comma-separated name=minimum pairs, e.g. temp=-50; the lower values are left out."
+            >
+              Clamp min
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              defaultValue={formatBounds(clampMin)}
+              onChange={this.onClampMinChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+            <InlineFormLabel
+              width={10}
+              tooltip="Comma-separated name=maximum pairs, e.g. hum=100; the higher values are left out."
+            >
+              Clamp max
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              defaultValue={formatBounds(clampMax)}
+              onChange={this.onClampMaxChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Field of the message holding the time of the rows, instead of the message timestamp."
+            >
+              Time field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={timeField || ''}
+              onChange={this.onTimeFieldChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+            <InlineFormLabel
+              width={10}
+              tooltip="auto (default), s, ms, us, ns or rfc3339; auto guesses the unit of the epochs from their magnitude."
+            >
+              Time format
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={timeFieldFormat || ''}
+              onChange={this.onTimeFieldFormatChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Order of the rows of every frame of the stream, which holds the messages arrived together from several partitions."
+            >
+              Sort by
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={sortOrders.find((option) => option.value === (sortBy || SortBy.Time))}
+                options={sortOrders}
+                onChange={this.onSortByChanged}
+              />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Field whose value names the series of the value field, e.g. metric in {"metric": "cpu", "value": 0.5}."
+            >
+              Name field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={nameField || ''}
+              onChange={this.onNameFieldChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+            <InlineFormLabel
+              width={10}
+              tooltip="Field holding the value of the series named by the name field."
+            >
+              Value field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={valueField || ''}
+              onChange={this.onValueFieldChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Add the __partition and __offset fields of the messages; the streamed values then link to the preview of their message."
+            >
+              Include metadata
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={includeMetadata || false} onChange={this.onIncludeMetadataChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Build the rows from the keys of the messages, decoded with the format, instead of their values; for state topics whose values are empty."
+            >
+              Decode keys
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={fromKey || false} onChange={this.onFromKeyChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Grouping of the messages of the topics matched by a pattern like ^metrics-.*, which requires all the partitions."
+            >
+              Frames
+            </InlineFormLabel>
+            <div className="gf-form--has-input-icon">
+              <Select
+                className="width-14"
+                value={frameModes.find((option) => option.value === (frameMode || FrameMode.Merged))}
+                options={frameModes}
+                onChange={this.onFrameModeChanged}
+              />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Drop the 5-byte prefix of the schema registry serializers before decoding, without contacting the registry; for JSON messages of the Confluent serializers."
+            >
+              Strip schema id
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={stripSchemaPrefix || false} onChange={this.onStripSchemaPrefixChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Starts the stream from the messages of that long ago, e.g. 5m, instead of the auto offset reset."
+            >
+              Start from
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={startRelative || ''}
+              onChange={this.onStartRelativeChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Starts the stream from the beginning of the time range of the dashboard instead of the auto offset reset."
+            >
+              Start at time range
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={useTimeRange || false} onChange={this.onUseTimeRangeChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Stops the stream with an error after that many messages in a row fail to decode, e.g. with the wrong format. 0 skips them all."
+            >
+              Max decode errors
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxConsecutiveDecodeErrors || ''}
+              onChange={this.onMaxConsecutiveDecodeErrorsChange}
+              onBlur={this.props.onRunQuery}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Adds the raw value of the messages in a __raw field, next to the decoded fields."
+            >
+              Include raw
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={includeRaw || false} onChange={this.onIncludeRawChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Bootstrap servers of another cluster to read from instead of the data source ones, among the allowed servers of the data source."
+            >
+              Servers
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={bootstrapServers || ''}
+              onChange={this.onBootstrapServersChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Field of the messages holding a bare JSON value, like a number, instead of an object. Defaults to value."
+            >
+              Scalar field
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={scalarFieldName || ''}
+              onChange={this.onScalarFieldNameChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Name of the frames, shown in the legends. Defaults to the topic, followed by the partition when one is selected."
+            >
+              Frame name
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={frameName || ''}
+              onChange={this.onFrameNameChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Truncates the string values beyond that many characters, 0 keeps them whole"
+            >
+              Max string length
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={maxStringLength || ''}
+              onChange={this.onMaxStringLengthChange}
+              onBlur={this.props.onRunQuery}
+              type="number"
+              step="1"
+              min="0"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="With all the partitions of a topic, reads every partition with a consumer of its own, so that a busy partition doesn't hold back the others."
+            >
+              Parallel partitions
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={parallelPartitions || false} onChange={this.onParallelPartitionsChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="With streaming, leaves out the zero values shown until the first messages arrive."
+            >
+              Suppress initial frame
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={suppressInitialFrame || false} onChange={this.onSuppressInitialFrameChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Only reads the messages whose key starts with the filter, or matches it when it starts with ^, e.g. user-42 or ^user-(42|43)$."
+            >
+              Key filter
+            </InlineFormLabel>
+            <input
+              className="gf-form-input width-14"
+              value={keyFilter || ''}
+              onChange={this.onKeyFilterChange}
+              onBlur={this.props.onRunQuery}
+              type="text"
+            />
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Adds a __seq field numbering the messages of the stream, so that the dropped ones show as gaps."
+            >
+              Include sequence
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={includeSeq || false} onChange={this.onIncludeSeqChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Drops the rows timed outside the time range the stream starts at, or before its start. The streams of a past time range stop at its end."
+            >
+              Strict time range
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={strictTimeRange || false} onChange={this.onStrictTimeRangeChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
+        <div className="gf-form">
+          <InlineFieldRow>
+            <InlineFormLabel
+              width={10}
+              tooltip="Shows every record as a row of all its fields, the arrays kept as JSON text, for the table and logs panels."
+            >
+              Event mode
+            </InlineFormLabel>
+            <div className="add-data-source-item-badge">
+              <Switch css checked={eventMode || false} onChange={this.onEventModeChange} />
+            </div>
+          </InlineFieldRow>
+        </div>
       </>
     );
   }
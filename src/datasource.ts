@@ -1,9 +1,17 @@
 import { DataSourceInstanceSettings } from '@grafana/data';
 import { DataSourceWithBackend } from '@grafana/runtime';
-import { KafkaDataSourceOptions, KafkaQuery } from './types';
+import { KafkaDataSourceOptions, KafkaQuery, QueryValidation } from './types';
 
 export class DataSource extends DataSourceWithBackend<KafkaQuery, KafkaDataSourceOptions> {
+  defaultTopic?: string;
+
   constructor(instanceSettings: DataSourceInstanceSettings<KafkaDataSourceOptions>) {
     super(instanceSettings);
+    this.defaultTopic = instanceSettings.jsonData.defaultTopic;
+  }
+
+  // Checks the topic, partition and offsets of the query against the cluster.
+  validateQuery(query: KafkaQuery): Promise<QueryValidation> {
+    return this.postResource('validate', query);
   }
 }
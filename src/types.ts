@@ -3,6 +3,14 @@ import { DataQuery, DataSourceJsonData } from '@grafana/data';
 export enum AutoOffsetReset {
   EARLIEST = 'earliest',
   LATEST = 'latest',
+  ERROR = 'error',
+}
+
+export enum QueryMode {
+  Messages = 'messages',
+  Offsets = 'offsets',
+  Snapshot = 'snapshot',
+  History = 'history',
 }
 
 export enum TimestampMode {
@@ -10,6 +18,39 @@ export enum TimestampMode {
   Message = 'message',
 }
 
+export enum MessageFormat {
+  JSON = 'json',
+  JSONArray = 'jsonarray',
+  NDJSON = 'ndjson',
+  CSV = 'csv',
+  ProtobufSR = 'protobuf-sr',
+  Base64 = 'base64',
+  Hex = 'hex',
+  String = 'string',
+  MsgPack = 'msgpack',
+  XML = 'xml',
+}
+
+export enum Aggregation {
+  None = '',
+  Count = 'count',
+  Sum = 'sum',
+  Avg = 'avg',
+  Min = 'min',
+  Max = 'max',
+}
+
+export enum SortBy {
+  Time = 'time',
+  Offset = 'offset',
+  None = 'none',
+}
+
+export enum FrameMode {
+  Merged = 'merged',
+  PerTopic = 'perTopic',
+}
+
 export type AutoOffsetResetInterface = {
   [key in AutoOffsetReset]: string;
 };
@@ -25,23 +66,124 @@ export interface KafkaDataSourceOptions extends DataSourceJsonData {
   saslUsername: string;
   debug: string;
   healthcheckTimeout: number;
+  sessionTimeoutMs: number;
+  heartbeatIntervalMs: number;
+  maxPollIntervalMs: number;
+  saslKerberosServiceName: string;
+  saslKerberosPrincipal: string;
+  saslKerberosKeytab: string;
+  saslKerberosKinitCmd: string;
+  defaultTopic: string;
+  defaultPartition: number;
+  maxMessageBytes: number;
+  fetchWaitMaxMs: number;
+  autoOffsetReset: string;
+  maxReconnectAttempts: number;
+  brokerAddressFamily: string;
+  clientDnsLookup: string;
+  charset: string;
+  invalidCharset: string;
+  metadataCacheTtlMs: number;
+  schemaRegistryUrl: string;
+  schemaRegistryUsername: string;
+  enableAutoCommit: boolean;
+  autoCommitIntervalMs: number;
+  deepHealthCheck: boolean;
+  isolationLevel: string;
+  partitionAssignmentStrategy: string;
+  tlsCaMode: string;
+  metadataTimeoutMs: number;
+  socketTimeoutMs: number;
+  allowInternalTopics: boolean;
+  queuedMaxMessagesKbytes: number;
+  queuedMinMessages: number;
+  reconnectBackoffMs: number;
+  reconnectBackoffMaxMs: number;
+  keepaliveIntervalMs: number;
+  maxConcurrentStreams: number;
+  apiKey?: string;
+  allowedBootstrapServers: string;
+  allowStreamControl: boolean;
+  clientRack: string;
+  groupInstanceId: string;
+  statisticsIntervalMs: number;
 }
 
 export interface KafkaSecureJsonData {
   saslPassword?: string;
+  apiSecret?: string;
+  schemaRegistryPassword?: string;
+  tlsCaCert?: string;
 }
 
 export interface KafkaQuery extends DataQuery {
   topicName: string;
   partition: number;
   withStreaming: boolean;
-  autoOffsetReset: AutoOffsetReset;
+  autoOffsetReset?: AutoOffsetReset;
   timestampMode: TimestampMode;
+  prefetchLast: number;
+  includeFields?: string[];
+  timestampFields?: string[];
+  excludeFields?: string[];
+  format: MessageFormat;
+  csvHeader?: string;
+  csvDelimiter?: string;
+  sampleRate?: number;
+  maxMessagesPerSecond?: number;
+  aggregation?: Aggregation;
+  aggregationWindow?: string;
+  fromOffset?: number;
+  toOffset?: number;
+  mode?: QueryMode;
+  seriesTimeField?: string;
+  seriesValueField?: string;
+  maxFields?: number;
+  skipTombstones?: boolean;
+  fieldAliases?: Record<string, string>;
+  clampMin?: Record<string, number>;
+  clampMax?: Record<string, number>;
+  timeField?: string;
+  timeFieldFormat?: string;
+  sortBy?: SortBy;
+  nameField?: string;
+  valueField?: string;
+  includeMetadata?: boolean;
+  fromKey?: boolean;
+  frameMode?: FrameMode;
+  stripSchemaPrefix?: boolean;
+  startRelative?: string;
+  useTimeRange?: boolean;
+  maxConsecutiveDecodeErrors?: number;
+  includeRaw?: boolean;
+  bootstrapServers?: string;
+  scalarFieldName?: string;
+  frameName?: string;
+  maxStringLength?: number;
+  parallelPartitions?: boolean;
+  suppressInitialFrame?: boolean;
+  keyFilter?: string;
+  includeSeq?: boolean;
+  strictTimeRange?: boolean;
+  eventMode?: boolean;
+}
+
+export interface QueryValidationError {
+  field: string;
+  message: string;
 }
 
+export interface QueryValidation {
+  valid: boolean;
+  errors: QueryValidationError[];
+}
+
+export const ALL_PARTITIONS = -1;
+
 export const defaultQuery: Partial<KafkaQuery> = {
-  partition: 0,
+  partition: ALL_PARTITIONS,
   withStreaming: true,
-  autoOffsetReset: AutoOffsetReset.LATEST,
   timestampMode: TimestampMode.Now,
+  prefetchLast: 0,
+  format: MessageFormat.JSON,
 };